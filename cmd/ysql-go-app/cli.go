@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/ssherwood/ysqlapp/internal/config"
+	"github.com/ssherwood/ysqlapp/internal/module"
+	"github.com/ssherwood/ysqlapp/internal/shared"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// exit codes returned by every command
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands = []command{
+	{"serve", "run the HTTP service (the default without a command)", serveCommand},
+	{"migrate", "apply, revert or list the schema migrations", migrateCommand},
+	{"seed", "generate synthetic locations into the database and/or as NDJSON", seedCommand},
+	{"config", "print or validate the effective configuration", configCommand},
+	{"db", "check the database connection", dbCommand},
+	{"version", "print the build version", versionCommand},
+}
+
+// run dispatches the command line to a command and returns the process exit code
+func run(args []string) int {
+	if len(args) == 0 {
+		return serveCommand(nil)
+	}
+
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		printUsage(os.Stdout)
+		return exitOK
+	}
+
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:])
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command '%s'\n\n", args[0])
+	printUsage(os.Stderr)
+	return exitUsage
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: ysql-go-app <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-9s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Flags override the corresponding environment variables, run 'ysql-go-app <command> -h' for them.")
+}
+
+// newFlagSet returns a flag set for the command that reports errors rather than exiting
+func newFlagSet(name, usage string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: ysql-go-app %s\n", usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseFlags parses the command's flags, when it returns false the command should exit with the returned code
+func parseFlags(flags *flag.FlagSet, args []string) (int, bool) {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK, false
+		}
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+// configFlags holds the -config file and the flags overriding individual settings, keyed by the environment
+// variable they override
+type configFlags struct {
+	path      string
+	overrides map[string]string
+}
+
+// newConfigFlags registers -config, the file defaults to $CONFIG_FILE
+func newConfigFlags(flags *flag.FlagSet) *configFlags {
+	c := &configFlags{overrides: map[string]string{}}
+	flags.StringVar(&c.path, "config", os.Getenv("CONFIG_FILE"), "YAML configuration file (CONFIG_FILE)")
+	return c
+}
+
+// setting registers a flag that overrides the setting of the environment variable
+func (c *configFlags) setting(flags *flag.FlagSet, name, env, usage string) {
+	flags.Func(name, fmt.Sprintf("%s (%s)", usage, env), func(value string) error {
+		c.overrides[env] = value
+		return nil
+	})
+}
+
+// boolSetting registers a boolean flag, given without a value it sets the setting to true
+func (c *configFlags) boolSetting(flags *flag.FlagSet, name, env, usage string) {
+	flags.BoolFunc(name, fmt.Sprintf("%s (%s)", usage, env), func(value string) error {
+		c.overrides[env] = value
+		return nil
+	})
+}
+
+// addDBFlags registers the database connection flags (DB_PASSWORD is deliberately not a flag)
+func (c *configFlags) addDBFlags(flags *flag.FlagSet) {
+	c.setting(flags, "db-hostname", "DB_HOSTNAME", "comma separated database host:port list")
+	c.setting(flags, "db-username", "DB_USERNAME", "database user")
+	c.setting(flags, "db-database", "DB_DATABASE", "database name")
+	c.setting(flags, "db-ssl-mode", "DB_SSL_MODE", "database sslmode")
+	c.setting(flags, "db-max-conns", "DB_MAX_CONNS", "maximum pool connections")
+	c.setting(flags, "db-min-conns", "DB_MIN_CONNS", "minimum pool connections")
+	c.setting(flags, "db-connect-timeout", "DB_CONNECT_TIMEOUT", "database connect timeout")
+}
+
+// addServerFlags registers the flags of the HTTP service
+func (c *configFlags) addServerFlags(flags *flag.FlagSet) {
+	c.setting(flags, "address", "SERVER_ADDRESS", "HTTP listen address")
+	c.setting(flags, "read-timeout", "SERVER_READ_TIMEOUT", "HTTP read timeout")
+	c.setting(flags, "write-timeout", "SERVER_WRITE_TIMEOUT", "HTTP write timeout")
+	c.setting(flags, "admin-address", "ADMIN_ADDRESS", "admin listen address (ADMIN_TOKEN is deliberately not a flag)")
+	c.setting(flags, "read-consistency", "DB_READ_CONSISTENCY", "default read consistency")
+	c.boolSetting(flags, "require-current-schema", "DB_REQUIRE_CURRENT_SCHEMA", "refuse to start with pending migrations")
+	c.setting(flags, "transition-interval", "LOCATION_TRANSITION_INTERVAL", "effective date transition job interval, 0 disables it")
+	c.setting(flags, "log-level", "LOG_LEVEL", "log level, debug, info, warn or error")
+	c.setting(flags, "log-format", "LOG_FORMAT", "console log format, text or json")
+}
+
+// load loads the configuration with the flags applied and makes it current, reporting every problem on stderr.
+// The default logger writes to stderr (stdout may carry the command's output) at LOG_LEVEL.
+func (c *configFlags) load() (*config.Config, bool) {
+	cfg, err := c.loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%s\n", err)
+		return nil, false
+	}
+
+	config.SetCurrent(cfg)
+	slog.SetDefault(slog.New(shared.NewConsoleHandler(os.Stderr, cfg.Log.Format)))
+	return cfg, true
+}
+
+// loadConfig loads the configuration from the file, environment and flags
+func (c *configFlags) loadConfig() (*config.Config, error) {
+	return config.Load(c.path, c.overrides, validateReadConsistency, validateModules)
+}
+
+// validateModules checks every module in MODULES is registered
+func validateModules(cfg *config.Config) error {
+	if _, err := module.Enabled(cfg.Modules.Enabled); err != nil {
+		return fmt.Errorf("MODULES: %w", err)
+	}
+	return nil
+}
+
+// validateReadConsistency checks the one setting whose format is owned outside the config package
+func validateReadConsistency(cfg *config.Config) error {
+	if _, err := shared.ParseReadConsistency(cfg.DB.ReadConsistency); err != nil {
+		return fmt.Errorf("DB_READ_CONSISTENCY: %w", err)
+	}
+	return nil
+}
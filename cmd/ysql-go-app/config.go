@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const configUsage = "config print [-format yaml|env] | validate [flags]"
+
+// configCommand prints the effective configuration (secrets masked) or validates it
+func configCommand(args []string) int {
+	if len(args) == 0 || (args[0] != "print" && args[0] != "validate") {
+		fmt.Fprintln(os.Stderr, "usage: ysql-go-app "+configUsage)
+		return exitUsage
+	}
+
+	flags := newFlagSet("config", configUsage)
+	configFlags := newConfigFlags(flags)
+	configFlags.addServerFlags(flags)
+	configFlags.addDBFlags(flags)
+	format := flags.String("format", "yaml", "print format, yaml (the config file format) or env")
+	if code, ok := parseFlags(flags, args[1:]); !ok {
+		return code
+	}
+
+	cfg, ok := configFlags.load()
+	if !ok {
+		return exitFailure
+	}
+
+	if args[0] == "validate" {
+		fmt.Println("configuration is valid")
+		return exitOK
+	}
+
+	switch *format {
+	case "yaml":
+		if err := cfg.WriteYAML(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+	case "env":
+		for _, variable := range cfg.Environ() {
+			fmt.Println(variable)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, "usage: ysql-go-app "+configUsage)
+		return exitUsage
+	}
+
+	return exitOK
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/ssherwood/ysqlapp/internal/shared"
+	"os"
+	"time"
+)
+
+const dbUsage = "db ping [flags]"
+
+// dbCommand checks that the configured database is reachable
+func dbCommand(args []string) int {
+	if len(args) == 0 || args[0] != "ping" {
+		fmt.Fprintln(os.Stderr, "usage: ysql-go-app "+dbUsage)
+		return exitUsage
+	}
+
+	flags := newFlagSet("db", dbUsage)
+	configFlags := newConfigFlags(flags)
+	configFlags.addDBFlags(flags)
+	if code, ok := parseFlags(flags, args[1:]); !ok {
+		return code
+	}
+
+	cfg, ok := configFlags.load()
+	if !ok {
+		return exitFailure
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	db, err := shared.InitializeDB(ctx, cfg)
+	if err != nil {
+		return exitFailure
+	}
+	defer db.Close()
+
+	start := time.Now()
+	if err = shared.PingDB(ctx, db); err != nil {
+		return exitFailure
+	}
+	latency := time.Since(start)
+
+	var version string
+	if err = db.QueryRow(ctx, "select version()").Scan(&version); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	fmt.Printf("ok %s %s\n", latency.Round(time.Microsecond), version)
+	return exitOK
+}
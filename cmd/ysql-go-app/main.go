@@ -1,18 +1,10 @@
 package main
 
 import (
-	"context"
-	"github.com/ssherwood/ysqlapp/internal/app"
-	"github.com/ssherwood/ysqlapp/internal/config"
-	"log/slog"
+	"os"
+	_ "time/tzdata" // opening hours time zones must resolve even without a system zoneinfo
 )
 
 func main() {
-	locationApp := &app.LocationApplication{}
-
-	if err := locationApp.Initialize(context.Background()); err != nil {
-		slog.Error("Failed to initialize application", config.ErrAttr(err))
-	}
-
-	locationApp.Run()
+	os.Exit(run(os.Args[1:]))
 }
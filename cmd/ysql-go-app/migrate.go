@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/ssherwood/ysqlapp/internal/config"
+	"github.com/ssherwood/ysqlapp/internal/module"
+	"github.com/ssherwood/ysqlapp/internal/shared"
+	"log/slog"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const migrateUsage = "migrate up|down [flags] [N] | status [flags]"
+
+// migrateCommand runs "migrate up [N]", "migrate down [N]" or "migrate status" against the configured database
+// and returns the process exit code
+func migrateCommand(args []string) int {
+	if len(args) == 0 || (args[0] != "up" && args[0] != "down" && args[0] != "status") {
+		fmt.Fprintln(os.Stderr, "usage: ysql-go-app "+migrateUsage)
+		return exitUsage
+	}
+
+	flags := newFlagSet("migrate", migrateUsage)
+	configFlags := newConfigFlags(flags)
+	configFlags.addDBFlags(flags)
+	if code, ok := parseFlags(flags, args[1:]); !ok {
+		return code
+	}
+
+	var steps int
+	switch {
+	case flags.NArg() > 1 || (flags.NArg() == 1 && args[0] == "status"):
+		fmt.Fprintln(os.Stderr, "usage: ysql-go-app "+migrateUsage)
+		return exitUsage
+	case flags.NArg() == 1:
+		var err error
+		if steps, err = strconv.Atoi(flags.Arg(0)); err != nil || steps < 0 {
+			fmt.Fprintln(os.Stderr, "usage: ysql-go-app "+migrateUsage)
+			return exitUsage
+		}
+	}
+
+	cfg, ok := configFlags.load()
+	if !ok {
+		return exitFailure
+	}
+
+	ctx := context.Background()
+	db, err := shared.InitializeDB(ctx, cfg)
+	if err != nil {
+		return exitFailure
+	}
+	defer db.Close()
+
+	modules, err := module.Enabled(cfg.Modules.Enabled)
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to load modules", config.ErrAttr(err))
+		return exitFailure
+	}
+
+	migrator, err := module.NewMigrator(db, modules)
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to load migrations", config.ErrAttr(err))
+		return exitFailure
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx, steps)
+		if err != nil {
+			slog.ErrorContext(ctx, "Migration failed", config.ErrAttr(err))
+			return exitFailure
+		}
+		slog.InfoContext(ctx, "Migrated up", slog.Int("applied", len(applied)))
+	case "down":
+		reverted, err := migrator.Down(ctx, steps)
+		if err != nil {
+			slog.ErrorContext(ctx, "Migration failed", config.ErrAttr(err))
+			return exitFailure
+		}
+		slog.InfoContext(ctx, "Migrated down", slog.Int("reverted", len(reverted)))
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "Unable to read migration status", config.ErrAttr(err))
+			return exitFailure
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, applied)
+		}
+		_ = w.Flush()
+	}
+
+	return exitOK
+}
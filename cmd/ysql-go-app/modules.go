@@ -0,0 +1,7 @@
+package main
+
+// The domains compiled into the service, each registers its module from init and MODULES selects which of them run.
+// A new domain only needs its import added here.
+import (
+	_ "github.com/ssherwood/ysqlapp/internal/location"
+)
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"github.com/ssherwood/ysqlapp/internal/config"
+	"github.com/ssherwood/ysqlapp/internal/seed"
+	"github.com/ssherwood/ysqlapp/internal/shared"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// seedCommand generates synthetic locations and loads them into the configured database and/or writes them as
+// NDJSON, it returns the process exit code
+func seedCommand(args []string) int {
+	flags := newFlagSet("seed", "seed [flags]")
+	opts := seed.Options{ReferenceDate: seed.DefaultReferenceDate}
+	flags.Uint64Var(&opts.Seed, "seed", 1, "seed value, the same seed always generates the same data")
+	flags.IntVar(&opts.Locations, "locations", 5000, "number of locations to generate")
+	flags.IntVar(&opts.Addresses, "addresses", 1000, "number of addresses to generate, shared round-robin by the locations")
+	flags.IntVar(&opts.BatchSize, "batch-size", 1000, "rows per COPY batch")
+	flags.Func("reference-date", "the day (YYYY-MM-DD) effective windows are scheduled around (default "+
+		seed.DefaultReferenceDate.Format(time.DateOnly)+")", func(value string) (err error) {
+		opts.ReferenceDate, err = time.Parse(time.DateOnly, value)
+		return err
+	})
+	ndjson := flags.String("ndjson", "", "also write the locations as NDJSON to this file ('-' for stdout)")
+	skipDB := flags.Bool("skip-db", false, "only write the NDJSON, do not load the database")
+	configFlags := newConfigFlags(flags)
+	configFlags.addDBFlags(flags)
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	ctx := context.Background()
+	generator, err := seed.NewGenerator(opts)
+	if err != nil {
+		slog.ErrorContext(ctx, "Invalid seed options", config.ErrAttr(err))
+		return exitUsage
+	}
+
+	if *ndjson != "" {
+		if err := writeNDJSON(*ndjson, generator); err != nil {
+			slog.ErrorContext(ctx, "Unable to write NDJSON", config.ErrAttr(err))
+			return exitFailure
+		}
+	}
+
+	if *skipDB {
+		return exitOK
+	}
+
+	cfg, ok := configFlags.load()
+	if !ok {
+		return exitFailure
+	}
+
+	db, err := shared.InitializeDB(ctx, cfg)
+	if err != nil {
+		return exitFailure
+	}
+	defer db.Close()
+
+	if err := seed.Load(ctx, db, generator); err != nil {
+		slog.ErrorContext(ctx, "Seed failed", config.ErrAttr(err))
+		return exitFailure
+	}
+	return exitOK
+}
+
+func writeNDJSON(path string, generator *seed.Generator) error {
+	var out io.Writer = os.Stdout
+	if path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	w := bufio.NewWriter(out)
+	if err := seed.WriteNDJSON(w, generator); err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"github.com/ssherwood/ysqlapp/internal/app"
+	"github.com/ssherwood/ysqlapp/internal/config"
+	"log/slog"
+)
+
+// serveCommand runs the HTTP service until it is signalled to stop
+func serveCommand(args []string) int {
+	flags := newFlagSet("serve", "serve [flags]")
+	configFlags := newConfigFlags(flags)
+	configFlags.addServerFlags(flags)
+	configFlags.addDBFlags(flags)
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	cfg, ok := configFlags.load()
+	if !ok {
+		return exitFailure
+	}
+
+	ctx := context.Background()
+	locationApp := &app.LocationApplication{Config: cfg, LoadConfig: configFlags.loadConfig}
+
+	if err := locationApp.Initialize(ctx); err != nil {
+		slog.ErrorContext(ctx, "Failed to initialize application", config.ErrAttr(err))
+
+		// release whatever was initialized before the failure, e.g. the database pool
+		shutdownCtx, cancel := context.WithTimeout(ctx, cfg.Server.ShutdownTimeout)
+		defer cancel()
+		_ = locationApp.Shutdown(shutdownCtx)
+		return exitFailure
+	}
+
+	if err := locationApp.Run(ctx); err != nil {
+		return exitFailure
+	}
+	return exitOK
+}
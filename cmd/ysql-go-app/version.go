@@ -0,0 +1,18 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ssherwood/ysqlapp/internal/config"
+)
+
+// versionCommand prints the service version with the VCS revision and Go version it was built from
+func versionCommand([]string) int {
+	build := config.Build()
+	revision := build.Revision
+	if build.Modified {
+		revision += "-dirty"
+	}
+
+	fmt.Printf("ysql-go-app %s (revision %s, %s)\n", build.Version, revision, build.GoVersion)
+	return exitOK
+}
@@ -0,0 +1,31 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"github.com/gorilla/mux"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// RequireToken rejects requests without "Authorization: Bearer <token>" with 401 Unauthorized, the public paths
+// (e.g. the health probes) are let through. An empty token matches nothing, so every other request is rejected.
+func RequireToken(token string, public ...string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slices.Contains(public, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			presented, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if token == "" || !found || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package admin
+
+import (
+	"encoding/json"
+	"expvar"
+	"github.com/gorilla/mux"
+	"github.com/ssherwood/ysqlapp/internal/config"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"time"
+)
+
+var startedAt = time.Now()
+
+type runtimeResponse struct {
+	config.BuildInfo
+	StartedAt     time.Time `json:"started_at"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+	Goroutines    int       `json:"goroutines"`
+	GOMAXPROCS    int       `json:"gomaxprocs"`
+	NumCPU        int       `json:"num_cpu"`
+	HeapAllocMB   float64   `json:"heap_alloc_mb"`
+	NumGC         uint32    `json:"num_gc"`
+}
+
+// RegisterDebug adds pprof, expvar, build/runtime info and goroutine dumps to the router, it is only meant for the
+// admin listener
+func RegisterDebug(r *mux.Router) {
+	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	r.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index)
+	r.Handle("/debug/vars", expvar.Handler())
+	r.HandleFunc("/admin/buildinfo", BuildInfo).Methods("GET")
+	r.HandleFunc("/admin/goroutines", Goroutines).Methods("GET")
+}
+
+// BuildInfo returns the build of the running binary along with a few runtime figures
+func BuildInfo(w http.ResponseWriter, _ *http.Request) {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(runtimeResponse{
+		BuildInfo:     config.Build(),
+		StartedAt:     startedAt,
+		UptimeSeconds: int64(time.Since(startedAt).Seconds()),
+		Goroutines:    runtime.NumGoroutine(),
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+		NumCPU:        runtime.NumCPU(),
+		HeapAllocMB:   float64(memStats.HeapAlloc) / (1 << 20),
+		NumGC:         memStats.NumGC,
+	})
+}
+
+// Goroutines writes the stack of every goroutine in the same format as an unrecovered panic
+func Goroutines(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_ = runtimepprof.Lookup("goroutine").WriteTo(w, 2)
+}
@@ -0,0 +1,116 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/ssherwood/ysqlapp/internal/config"
+	"github.com/ssherwood/ysqlapp/internal/shared"
+	"github.com/yugabyte/pgx/v5/pgxpool"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Handler struct {
+	db *pgxpool.Pool
+}
+
+func NewHandler(r *mux.Router, db *pgxpool.Pool) *Handler {
+	handler := &Handler{db: db}
+	r.HandleFunc("/admin/config", handler.GetConfig).Methods("GET")
+	r.HandleFunc("/admin/config", handler.UpdateConfig).Methods("PUT")
+	r.HandleFunc("/admin/db/pool", handler.GetPool).Methods("GET")
+	r.HandleFunc("/admin/db/activity", handler.GetActivity).Methods("GET")
+	r.HandleFunc("/admin/tracing", handler.GetTracing).Methods("GET")
+	return handler
+}
+
+type configResponse struct {
+	Settings   map[string]string `json:"settings"`
+	Reloadable []string          `json:"reloadable"`
+}
+
+type updateConfigResponse struct {
+	Changes []config.Change `json:"changes"`
+}
+
+type activityResponse struct {
+	Activity []shared.Activity  `json:"activity"`
+	Errors   []shared.NodeError `json:"errors,omitempty"`
+}
+
+// GetConfig returns the effective configuration keyed by environment variable name (secrets masked) along with
+// the settings that can be changed without a restart
+func (h *Handler) GetConfig(w http.ResponseWriter, r *http.Request) {
+	response := configResponse{Settings: map[string]string{}, Reloadable: config.Reloadable()}
+	for _, variable := range config.Current().Environ() {
+		name, value, _ := strings.Cut(variable, "=")
+		response.Settings[name] = value
+	}
+
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// UpdateConfig changes reloadable settings, e.g. {"LOG_LEVEL": "debug", "OTEL_TRACES_SAMPLER_ARG": 0.1}. The values
+// are strings, numbers or booleans written as they would be in the environment, so a list is a comma separated
+// string. Nothing is changed unless every setting is reloadable and valid.
+func (h *Handler) UpdateConfig(w http.ResponseWriter, r *http.Request) {
+	var body map[string]any
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	values := make(map[string]string, len(body))
+	for name, value := range body {
+		switch value := value.(type) {
+		case string:
+			values[name] = value
+		case json.Number:
+			values[name] = value.String()
+		case bool:
+			values[name] = strconv.FormatBool(value)
+		default:
+			http.Error(w, fmt.Sprintf("%s: must be a string, number or boolean, a list is a comma separated string", name),
+				http.StatusBadRequest)
+			return
+		}
+	}
+
+	changes, err := config.Update(r.Context(), values, "PUT /admin/config")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(updateConfigResponse{Changes: changes})
+}
+
+// GetPool returns the pool stats and every pooled connection with its host, age, idle or in use time and session
+// settings
+func (h *Handler) GetPool(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	_ = json.NewEncoder(w).Encode(shared.InspectPool(ctx, h.db))
+}
+
+// GetActivity returns this instance's sessions from pg_stat_activity on each database host, a host that could
+// not be reached is reported in errors
+func (h *Handler) GetActivity(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	activity, nodeErrors := shared.PoolActivity(ctx, h.db)
+	_ = json.NewEncoder(w).Encode(activityResponse{Activity: activity, Errors: nodeErrors})
+}
+
+// GetTracing returns the effective trace sampling policy, it follows the configuration as it is reloaded
+func (h *Handler) GetTracing(w http.ResponseWriter, _ *http.Request) {
+	_ = json.NewEncoder(w).Encode(shared.SamplingPolicy())
+}
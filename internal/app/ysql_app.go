@@ -3,75 +3,142 @@ package app
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/ssherwood/ysqlapp/internal/admin"
 	"github.com/ssherwood/ysqlapp/internal/config"
-	"github.com/ssherwood/ysqlapp/internal/location"
+	"github.com/ssherwood/ysqlapp/internal/health"
+	"github.com/ssherwood/ysqlapp/internal/lifecycle"
+	"github.com/ssherwood/ysqlapp/internal/module"
 	"github.com/ssherwood/ysqlapp/internal/shared"
 	"github.com/yugabyte/pgx/v5/pgxpool"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/sdk/log"
 	metricsdk "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"runtime"
+	"runtime/pprof"
+	"strings"
 	"syscall"
 	"time"
 )
 
 type Application interface {
 	Initialize(ctx context.Context) error
-	Run()
-	Shutdown(ctx context.Context)
+	Run(ctx context.Context) error
+	Shutdown(ctx context.Context) error
 }
 
+var _ Application = (*LocationApplication)(nil)
+
 type LocationApplication struct {
+	Config *config.Config
+	// LoadConfig loads the configuration again on SIGHUP, its reloadable settings are applied live
+	LoadConfig func() (*config.Config, error)
+
 	Server          *http.Server
 	Router          *mux.Router
+	AdminServer     *http.Server
+	AdminRouter     *mux.Router
 	TracerProvider  *trace.TracerProvider
 	MetricsProvider *metricsdk.MeterProvider
 	LoggerProvider  *log.LoggerProvider
 	DB              *pgxpool.Pool
 	TestCtr         metric.Int64Counter
+	Health          *health.Checker
+	// MetricsHandler serves the metrics to Prometheus on the admin listener, nil unless OTEL_PROMETHEUS_ENABLE
+	MetricsHandler http.Handler
+	// Lifecycle starts the components in Run and stops them in Shutdown
+	Lifecycle *lifecycle.Manager
 }
 
+// the stop timeout of components that do not derive one from their configuration
+const defaultStopTimeout = 5 * time.Second
+
 func (app *LocationApplication) Initialize(ctx context.Context) error {
-	//if lp, err := shared.InitializeLoggingProvider(ctx); err != nil {
-	//	return err
-	//} else {
-	//	app.LoggerProvider = lp
-	//
-	//	consoleHandler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{})
-	//	otelHandler := shared.NewOTLPSlogHandler(consoleHandler, lp)
-	//	logger := slog.New(otelHandler)
-	//
-	//	otelLogger := slog.New(otelslog.NewOtelHandler(otel.GetLoggerProvider(), &otelslog.HandlerOptions{}))
-	//	slog.SetDefault(otelLogger)
-	//}
+	shared.InitTelemetryErrorHandler()
+	app.Lifecycle = lifecycle.NewManager(defaultStopTimeout)
 
-	//if tp, err := shared.InitTracerProvider(ctx); err != nil {
-	//	return err
-	//} else {
-	//	app.TracerProvider = tp
-	//}
+	// each signal is exported as OTEL_TRACES_EXPORTER, OTEL_METRICS_EXPORTER and OTEL_LOGS_EXPORTER select, the
+	// global no-op providers stay in place for those set to none
+	if app.Config.OTel.LogsExporter != config.ExporterNone {
+		if lp, err := shared.InitializeLoggingProvider(ctx, app.Config.OTel); err != nil {
+			return err
+		} else {
+			app.LoggerProvider = lp
 
-	//if mp, err := shared.InitializeMetricProvider(ctx); err != nil {
-	//	return err
-	//} else {
-	//	app.MetricsProvider = mp
-	//}
+			// keep writing to the console handler set up by the command, and emit every record to OTEL as well
+			otelHandler := shared.NewOTLPLogHandler(slog.Default().Handler(), lp, config.LogLevel)
+			slog.SetDefault(slog.New(otelHandler))
+		}
+	}
+
+	if app.Config.OTel.TracesExporter != config.ExporterNone {
+		if tp, err := shared.InitTracerProvider(ctx, app.Config.OTel); err != nil {
+			return err
+		} else {
+			app.TracerProvider = tp
+		}
+	}
+
+	var metricReaders []metricsdk.Reader
+	if app.Config.OTel.PrometheusEnabled {
+		if reader, handler, err := shared.NewPrometheusReader(); err != nil {
+			return err
+		} else {
+			metricReaders = append(metricReaders, reader)
+			app.MetricsHandler = handler
+		}
+	}
+
+	if app.Config.OTel.MetricsExporter != config.ExporterNone || len(metricReaders) > 0 {
+		if mp, err := shared.InitializeMetricProvider(ctx, app.Config.OTel, metricReaders...); err != nil {
+			return err
+		} else {
+			app.MetricsProvider = mp
+		}
+	}
 
 	//if err := app.metricTest(ctx); err != nil {
 	//	return err
 	//}
 
-	if db, err := shared.InitializeDB(ctx); err != nil {
+	// the telemetry providers stop last, flushing whatever the other components emit while they stop
+	var telemetry []string
+	addTelemetry := func(name string, stop func(context.Context) error) {
+		app.Lifecycle.Add(lifecycle.Component{Name: name, Stop: stop})
+		telemetry = append(telemetry, name)
+	}
+	if app.LoggerProvider != nil {
+		addTelemetry("logger_provider", app.LoggerProvider.Shutdown)
+	}
+	if app.TracerProvider != nil {
+		addTelemetry("tracer_provider", app.TracerProvider.Shutdown)
+	}
+	if app.MetricsProvider != nil {
+		addTelemetry("meter_provider", app.MetricsProvider.Shutdown)
+	}
+
+	if db, err := shared.InitializeDB(ctx, app.Config); err != nil {
 		return err
 	} else {
 		app.DB = db
+		// in-flight queries end within the statement timeout, so draining longer than that only waits on idle
+		// transactions
+		app.Lifecycle.Add(lifecycle.Component{
+			Name:        module.DependsOnDB,
+			DependsOn:   telemetry,
+			Stop:        func(ctx context.Context) error { return shared.DrainPool(ctx, db) },
+			StopTimeout: app.Config.DB.StatementTimeout,
+		})
 
 		// force establishing at least one valid connection
 		if err = shared.PingDB(ctx, db); err != nil {
@@ -79,24 +146,113 @@ func (app *LocationApplication) Initialize(ctx context.Context) error {
 		}
 	}
 
+	modules, err := module.Enabled(app.Config.Modules.Enabled)
+	if err != nil {
+		return err
+	}
+
+	migrator, err := module.NewMigrator(app.DB, modules)
+	if err != nil {
+		return err
+	}
+	if app.Config.DB.RequireCurrentSchema {
+		if err = migrator.RequireCurrent(ctx); err != nil {
+			return err
+		}
+	}
+
+	readConsistency, err := shared.ParseReadConsistency(app.Config.DB.ReadConsistency)
+	if err != nil {
+		return err
+	}
+
+	httpMetrics, err := shared.NewHTTPMetricsMiddleware()
+	if err != nil {
+		return err
+	}
+
 	app.Router = mux.NewRouter()
 	app.Router.Use(otelmux.Middleware(config.ServiceName))
+	app.Router.Use(shared.RequestContextMiddleware())
+	app.Router.Use(httpMetrics)
+	app.Router.Use(shared.ReadConsistencyMiddleware(readConsistency, app.Config.DB.ConsistencyTokenMargin))
+
+	// pending migrations only make the service not ready when it is required to run on the current schema
+	app.Health = health.NewChecker(app.Config.Health.CacheTTL, app.Config.Health.CheckTimeout)
+	app.Health.Add("database", true, health.DatabaseCheck(app.DB))
+	app.Health.Add("db_pool", true, health.PoolSaturationCheck(app.DB, app.Config.Health.PoolSaturationThreshold))
+	app.Health.Add("migrations", app.Config.DB.RequireCurrentSchema, health.MigrationCheck(migrator))
+	app.Health.Add("telemetry", false, health.TelemetryCheck(app.Config.Health.TelemetryErrorWindow))
 
-	locationRepository := location.NewRepository(app.DB)
-	locationService := location.NewService(locationRepository)
-	_ = location.NewHandler(app.Router, locationService, app.DB)
+	for _, m := range modules {
+		host := module.NewHost(m, app.Config, app.DB, app.Router, app.moduleMeter(m), app.Health, app.Lifecycle)
+		if err = m.Init(ctx, host); err != nil {
+			return fmt.Errorf("module %s: %w", m.Name(), err)
+		}
+		slog.InfoContext(ctx, "Initialized module", config.SlogServiceName, slog.String("module", m.Name()))
+	}
+
+	// the admin routes change the configuration and expose the database sessions, so they are only served by the
+	// admin listener, which also takes over the health routes (otherwise served by the public router)
+	healthRouter := app.Router
+	if app.Config.Admin.Address != "" {
+		app.AdminRouter = mux.NewRouter()
+		app.AdminRouter.Use(shared.RequestContextMiddleware())
+		// Validate only allows a listener without a token on a loopback address
+		if app.Config.Admin.Token != "" {
+			app.AdminRouter.Use(admin.RequireToken(app.Config.Admin.Token, "/livez", "/readyz"))
+		} else if config.IsLoopback(app.Config.Admin.Address) {
+			slog.WarnContext(ctx, "Admin listener has no ADMIN_TOKEN, it is only reachable from this host",
+				config.SlogServiceName, slog.String("address", app.Config.Admin.Address))
+		} else {
+			return fmt.Errorf("ADMIN_TOKEN: required when ADMIN_ADDRESS ('%s') is not a loopback address",
+				app.Config.Admin.Address)
+		}
+		admin.RegisterDebug(app.AdminRouter)
+		if app.MetricsHandler != nil {
+			app.AdminRouter.Handle("/metrics", app.MetricsHandler).Methods("GET")
+		}
+		_ = admin.NewHandler(app.AdminRouter, app.DB)
+		healthRouter = app.AdminRouter
+	}
+	app.Health.Register(healthRouter)
 
 	app.Server = &http.Server{
 		Handler:      app.Router,
-		Addr:         config.ServerAddress,
-		WriteTimeout: config.ServerWriteTimeout,
-		ReadTimeout:  config.ServerReadTimeout,
+		Addr:         app.Config.Server.Address,
+		WriteTimeout: app.Config.Server.WriteTimeout,
+		ReadTimeout:  app.Config.Server.ReadTimeout,
 		//ErrorLog:     slog.Default(),
 	}
 
+	if app.AdminRouter != nil {
+		// no write timeout, CPU profiles and execution traces stream for as long as requested
+		app.AdminServer = &http.Server{
+			Handler:     app.AdminRouter,
+			Addr:        app.Config.Admin.Address,
+			ReadTimeout: app.Config.Server.ReadTimeout,
+		}
+	}
+
+	// requests cannot outlive the write timeout, so that is as long as the server waits for them to finish
+	app.Lifecycle.Add(lifecycle.HTTPServer("http_server", app.Server, app.Config.Server.WriteTimeout, module.DependsOnDB))
+	if app.AdminServer != nil {
+		app.Lifecycle.Add(lifecycle.HTTPServer("admin_server", app.AdminServer, defaultStopTimeout, module.DependsOnDB))
+	}
+
 	return nil
 }
 
+// moduleMeter scopes the module's metrics to its package
+func (app *LocationApplication) moduleMeter(m module.Module) metric.Meter {
+	var provider metric.MeterProvider = otel.GetMeterProvider()
+	if app.MetricsProvider != nil {
+		provider = app.MetricsProvider
+	}
+	return provider.Meter("github.com/ssherwood/ysqlapp/internal/"+m.Name(),
+		metric.WithInstrumentationAttributes(semconv.ServiceName(config.ServiceName)))
+}
+
 // this is just a test metric for now
 func (app *LocationApplication) metricTest(ctx context.Context) error {
 	meter := app.MetricsProvider.Meter("foo")
@@ -111,64 +267,100 @@ func (app *LocationApplication) metricTest(ctx context.Context) error {
 	return nil
 }
 
-func (app *LocationApplication) Run() {
-
-	go func() {
-		slog.Info("Starting application", config.SlogServiceName, config.SlogServiceAddress)
-		if err := app.Server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			slog.Warn("Failed to start application", config.SlogServiceName, config.ErrAttr(err))
-		}
-	}()
-
-	// Setup signal handling for graceful shutdown
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+// Run starts the components and serves until the process is signalled to stop, it returns the error that failed
+// the start or the shutdown
+func (app *LocationApplication) Run(ctx context.Context) error {
+	err := app.Lifecycle.Start(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to start application", config.SlogServiceName, config.ErrAttr(err))
+	} else {
+		app.waitForSignals(ctx)
+	}
 
 	// create a context with timeout for the shutdown process
-	cancelContext, cancelFn := context.WithTimeout(context.Background(), 15*time.Second)
+	cancelContext, cancelFn := context.WithTimeout(context.WithoutCancel(ctx), app.Config.Server.ShutdownTimeout)
 	defer cancelFn()
 
-	if err := app.Shutdown(cancelContext); err != nil {
-		slog.Info("Failed to gracefully shutdown", config.SlogServiceName, config.ErrAttr(err))
+	if shutdownErr := app.Shutdown(cancelContext); shutdownErr != nil {
+		slog.WarnContext(ctx, "Failed to gracefully shutdown", config.SlogServiceName, config.ErrAttr(shutdownErr))
+		err = errors.Join(err, shutdownErr)
 	}
 
-	slog.Info("Application stopped.", config.SlogServiceName)
+	slog.InfoContext(ctx, "Application stopped.", config.SlogServiceName)
+	return err
 }
 
-// Shutdown - invokes the global shutdown on the app to remove/close open resources
-func (app *LocationApplication) Shutdown(ctx context.Context) error {
-	slog.Info("Application shutting down...", config.SlogServiceName)
-
-	// TODO should we do these in parallel with goroutines?
-	if app.Server != nil {
-		if err := app.Server.Shutdown(ctx); err != nil {
-			slog.Warn("Unable to shutdown HTTP server", config.SlogServiceName, config.ErrAttr(err))
+// waitForSignals returns on SIGINT or SIGTERM, handling SIGHUP and SIGUSR1 meanwhile
+func (app *LocationApplication) waitForSignals(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1)
+waitForSignals:
+	for sig := range signals {
+		switch sig {
+		case syscall.SIGHUP:
+			app.reloadConfig(ctx)
+		case syscall.SIGUSR1:
+			app.dumpDiagnostics(ctx)
+		default:
+			break waitForSignals
 		}
 	}
+}
 
-	if app.MetricsProvider != nil {
-		if err := app.MetricsProvider.Shutdown(ctx); err != nil {
-			slog.Warn("Unable to shutdown OTEL metrics provider", config.SlogServiceName, config.ErrAttr(err))
-		}
+// reloadConfig loads the configuration again and applies its reloadable settings, an invalid configuration is
+// rejected as a whole and the running one kept
+func (app *LocationApplication) reloadConfig(ctx context.Context) {
+	if app.LoadConfig == nil {
+		slog.WarnContext(ctx, "Configuration reload is not supported", config.SlogServiceName)
+		return
 	}
 
-	if app.TracerProvider != nil {
-		if err := app.TracerProvider.Shutdown(ctx); err != nil {
-			slog.Warn("Unable to shutdown OTEL tracer provider", config.ErrAttr(err))
-		}
+	next, err := app.LoadConfig()
+	if err != nil {
+		slog.ErrorContext(ctx, "Configuration reload failed, keeping the current configuration", config.ErrAttr(err))
+		return
 	}
 
-	if app.LoggerProvider != nil {
-		if err := app.LoggerProvider.Shutdown(ctx); err == nil {
-			slog.Warn("Unable to shutdown OTEL logger provider", config.ErrAttr(err))
+	config.Apply(next, "SIGHUP")
+}
+
+// dumpDiagnostics logs the stack of every goroutine and the state of the pool and its connections, for when the
+// service is stuck and the admin listener is not enabled or not reachable
+func (app *LocationApplication) dumpDiagnostics(ctx context.Context) {
+	var goroutines strings.Builder
+	_ = pprof.Lookup("goroutine").WriteTo(&goroutines, 2)
+	slog.InfoContext(ctx, "Goroutine dump", config.SlogServiceName,
+		slog.Int("goroutines", runtime.NumGoroutine()), slog.String("stacks", goroutines.String()))
+
+	if app.DB != nil {
+		pool := shared.PoolSnapshot(app.DB)
+		slog.InfoContext(ctx, "Pool state", config.SlogServiceName, slog.Any("stats", pool.Stats), slog.Any("connections", pool.Connections))
+	}
+}
+
+// Shutdown - invokes the global shutdown on the app to remove/close open resources
+func (app *LocationApplication) Shutdown(ctx context.Context) error {
+	slog.InfoContext(ctx, "Application shutting down...", config.SlogServiceName)
+
+	// report not ready first and keep serving while the load balancers notice and stop routing requests here
+	if app.Health != nil {
+		app.Health.SetShuttingDown()
+		if app.Lifecycle != nil && app.Lifecycle.Running("http_server") && app.Config.Server.DrainDelay > 0 {
+			slog.InfoContext(ctx, "Draining before stopping the HTTP server", slog.Duration("delay", app.Config.Server.DrainDelay))
+			select {
+			case <-time.After(app.Config.Server.DrainDelay):
+			case <-ctx.Done():
+			}
 		}
 	}
 
-	if app.DB != nil {
-		// TODO is this graceful for in-flight transactions?
-		app.DB.Close()
+	if app.Lifecycle == nil {
+		return nil
 	}
 
-	return nil
+	// components stop in reverse dependency order: the servers and jobs, then the pool once its in-flight work
+	// drained, then the telemetry providers
+	summary := app.Lifecycle.Stop(ctx)
+	slog.InfoContext(ctx, "Shutdown summary", config.SlogServiceName, slog.Any("shutdown", summary))
+	return summary.Err()
 }
@@ -0,0 +1,475 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	Hostname, _    = os.Hostname()
+	ServiceName    = "LocationService"
+	ServiceVersion = "1.0" // set at build time with -ldflags "-X github.com/ssherwood/ysqlapp/internal/config.ServiceVersion=..."
+)
+
+// the formats of the console log
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// the OTEL_TRACES_EXPORTER, OTEL_METRICS_EXPORTER and OTEL_LOGS_EXPORTER values
+const (
+	ExporterOTLP    = "otlp"
+	ExporterConsole = "console"
+	// ExporterFile writes JSON lines to a file in OTEL_EXPORTER_FILE_PATH, rotated at OTEL_EXPORTER_FILE_MAX_SIZE
+	ExporterFile = "file"
+	ExporterNone = "none"
+)
+
+var exporters = []string{ExporterOTLP, ExporterConsole, ExporterFile, ExporterNone}
+
+// the OTEL_EXPORTER_OTLP_PROTOCOL values
+const (
+	ProtocolGRPC         = "grpc"
+	ProtocolHTTPProtobuf = "http/protobuf"
+)
+
+// the OTEL_EXPORTER_OTLP_ENDPOINT of each protocol when none is given, the collector's default ports
+var defaultEndpoints = map[string]string{
+	ProtocolGRPC:         "localhost:4317",
+	ProtocolHTTPProtobuf: "localhost:4318",
+}
+
+var SlogServiceName = slog.String("service", ServiceName)
+
+// Config is the typed configuration of the service. Each setting can be given in the YAML file (by its yaml key)
+// and in the environment (by its env name), see Load for the precedence. Settings tagged reload:"true" can also be
+// changed while the service runs, see Apply and Update.
+type Config struct {
+	Modules ModulesConfig `yaml:"modules"`
+	Log     LogConfig     `yaml:"log"`
+	Server  ServerConfig  `yaml:"server"`
+	DB      DBConfig      `yaml:"db"`
+	Health  HealthConfig  `yaml:"health"`
+	Admin   AdminConfig   `yaml:"admin"`
+	OTel    OTelConfig    `yaml:"otel"`
+}
+
+type LogConfig struct {
+	Level slog.Level `yaml:"level" env:"LOG_LEVEL" reload:"true"`
+	// Format of the console log, text or json
+	Format string `yaml:"format" env:"LOG_FORMAT"`
+}
+
+type ServerConfig struct {
+	Address      string        `yaml:"address" env:"SERVER_ADDRESS"`
+	ReadTimeout  time.Duration `yaml:"read_timeout" env:"SERVER_READ_TIMEOUT"`
+	WriteTimeout time.Duration `yaml:"write_timeout" env:"SERVER_WRITE_TIMEOUT"`
+	// DrainDelay is how long Shutdown keeps serving while reporting not ready, so load balancers stop routing first
+	DrainDelay time.Duration `yaml:"drain_delay" env:"SERVER_DRAIN_DELAY"`
+	// ShutdownTimeout bounds the whole shutdown, drain delay included
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SERVER_SHUTDOWN_TIMEOUT"`
+}
+
+type DBConfig struct {
+	Username               string        `yaml:"username" env:"DB_USERNAME"`
+	Password               string        `yaml:"password" env:"DB_PASSWORD" secret:"true"`
+	Hosts                  []string      `yaml:"hosts" env:"DB_HOSTNAME"`
+	Database               string        `yaml:"database" env:"DB_DATABASE"`
+	ApplicationName        string        `yaml:"application_name" env:"DB_APPLICATION_NAME"`
+	PoolName               string        `yaml:"pool_name" env:"DB_POOL_NAME"`
+	SSLMode                string        `yaml:"ssl_mode" env:"DB_SSL_MODE"`
+	StatementTimeout       time.Duration `yaml:"statement_timeout" env:"DB_STATEMENT_TIMEOUT"`
+	LoadBalance            bool          `yaml:"load_balance" env:"DB_YSQL_LOAD_BALANCE"`
+	TopologyKeys           []string      `yaml:"topology_keys" env:"DB_YSQL_TOPOLOGY_KEYS"`
+	MaxConns               int32         `yaml:"max_conns" env:"DB_MAX_CONNS"`
+	MinConns               int32         `yaml:"min_conns" env:"DB_MIN_CONNS"`
+	MaxConnLifetime        time.Duration `yaml:"max_conn_lifetime" env:"DB_MAX_CONN_LIFETIME"`
+	MaxConnLifetimeJitter  time.Duration `yaml:"max_conn_lifetime_jitter" env:"DB_MAX_CONN_LIFETIME_JITTER"`
+	HealthCheckPeriod      time.Duration `yaml:"health_check_period" env:"DB_HEALTH_CHECK_PERIOD"`
+	ConnectTimeout         time.Duration `yaml:"connect_timeout" env:"DB_CONNECT_TIMEOUT"`
+	ReadConsistency        string        `yaml:"read_consistency" env:"DB_READ_CONSISTENCY"`
+	ConsistencyTokenMargin time.Duration `yaml:"consistency_token_margin" env:"DB_CONSISTENCY_TOKEN_MARGIN"`
+	RequireCurrentSchema   bool          `yaml:"require_current_schema" env:"DB_REQUIRE_CURRENT_SCHEMA"`
+}
+
+type HealthConfig struct {
+	CacheTTL                time.Duration `yaml:"cache_ttl" env:"HEALTH_CACHE_TTL"`
+	CheckTimeout            time.Duration `yaml:"check_timeout" env:"HEALTH_CHECK_TIMEOUT"`
+	PoolSaturationThreshold float64       `yaml:"pool_saturation_threshold" env:"HEALTH_POOL_SATURATION_THRESHOLD"`
+	TelemetryErrorWindow    time.Duration `yaml:"telemetry_error_window" env:"HEALTH_TELEMETRY_ERROR_WINDOW"`
+}
+
+// AdminConfig is the optional second listener for the admin, health and debug routes. When Address is empty the
+// health routes are served by the public listener and the admin and debug routes are not served at all.
+type AdminConfig struct {
+	Address string `yaml:"address" env:"ADMIN_ADDRESS"`
+	Token   string `yaml:"token" env:"ADMIN_TOKEN" secret:"true"`
+}
+
+type OTelConfig struct {
+	TracesExporter        string            `yaml:"traces_exporter" env:"OTEL_TRACES_EXPORTER"`
+	MetricsExporter       string            `yaml:"metrics_exporter" env:"OTEL_METRICS_EXPORTER"`
+	LogsExporter          string            `yaml:"logs_exporter" env:"OTEL_LOGS_EXPORTER"`
+	Endpoint              URL               `yaml:"endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	Protocol              string            `yaml:"protocol" env:"OTEL_EXPORTER_OTLP_PROTOCOL"`
+	FilePath              string            `yaml:"file_path" env:"OTEL_EXPORTER_FILE_PATH"`
+	FileMaxSize           int               `yaml:"file_max_size" env:"OTEL_EXPORTER_FILE_MAX_SIZE"`
+	FileMaxBackups        int               `yaml:"file_max_backups" env:"OTEL_EXPORTER_FILE_MAX_BACKUPS"`
+	PrometheusEnabled     bool              `yaml:"prometheus_enabled" env:"OTEL_PROMETHEUS_ENABLE"`
+	Insecure              bool              `yaml:"insecure" env:"OTEL_EXPORTER_INSECURE_MODE"`
+	Compressor            string            `yaml:"compressor" env:"OTEL_GRPC_COMPRESSOR"`
+	MetricInterval        time.Duration     `yaml:"metric_interval" env:"OTEL_METRIC_POLL_INTERVAL"`
+	HTTPDurationBuckets   []float64         `yaml:"http_duration_buckets" env:"OTEL_HTTP_DURATION_BUCKETS"`
+	HTTPSizeBuckets       []float64         `yaml:"http_size_buckets" env:"OTEL_HTTP_SIZE_BUCKETS"`
+	Sampler               string            `yaml:"sampler" env:"OTEL_TRACES_SAMPLER" reload:"true"`
+	SamplerRatio          float64           `yaml:"sampler_ratio" env:"OTEL_TRACES_SAMPLER_ARG" reload:"true"`
+	SamplerRules          []string          `yaml:"sampler_rules" env:"OTEL_TRACES_SAMPLER_RULES" reload:"true"`
+	SampleErrors          bool              `yaml:"sample_errors" env:"OTEL_TRACES_SAMPLE_ERRORS" reload:"true"`
+	SlowThreshold         time.Duration     `yaml:"slow_threshold" env:"OTEL_TRACES_SLOW_THRESHOLD" reload:"true"`
+	ResourceAttributes    map[string]string `yaml:"resource_attributes" env:"OTEL_RESOURCE_ATTRIBUTES"`
+	TracerEnabled         bool              `yaml:"tracer_enabled" env:"OTEL_TRACER_ENABLE"`
+	TracerLogSQLStatement bool              `yaml:"tracer_log_sql_statement" env:"OTEL_TRACER_LOG_SQL_STMT" reload:"true"`
+	TracerIncludeParams   bool              `yaml:"tracer_include_params" env:"OTEL_TRACER_INCLUDE_PARAMS" reload:"true"`
+	PrefixQuerySpanName   bool              `yaml:"prefix_query_span_name" env:"OTEL_PREFIX_QUERY_SPAN_NAME"`
+}
+
+// URL is a url.URL that can be read from text (env, YAML and flags), text without a scheme is read as host:port
+type URL struct {
+	url.URL
+}
+
+func MustParseURL(value string) URL {
+	var u URL
+	if err := u.UnmarshalText([]byte(value)); err != nil {
+		panic(err)
+	}
+	return u
+}
+
+func (u *URL) UnmarshalText(text []byte) error {
+	value := string(text)
+	if !strings.Contains(value, "://") {
+		value = "//" + value
+	}
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	u.URL = *parsed
+	return nil
+}
+
+func (u URL) MarshalText() ([]byte, error) {
+	return []byte(strings.TrimPrefix(u.String(), "//")), nil
+}
+
+// ExporterInsecure reports whether the OTLP exporters connect without TLS: an http:// endpoint never uses TLS and an
+// https:// endpoint always does, only a host:port endpoint follows OTEL_EXPORTER_INSECURE_MODE
+func (c OTelConfig) ExporterInsecure() bool {
+	switch c.Endpoint.Scheme {
+	case "http":
+		return true
+	case "https":
+		return false
+	default:
+		return c.Insecure
+	}
+}
+
+// Default returns the configuration used for every setting not given in the file or environment
+func Default() *Config {
+	return &Config{
+		Modules: ModulesConfig{
+			Enabled:  []string{"location"},
+			Sections: defaultSections(),
+		},
+		Log: LogConfig{
+			Level:  slog.LevelInfo,
+			Format: LogFormatText,
+		},
+		Server: ServerConfig{
+			Address:         ":8080",
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    15 * time.Second,
+			DrainDelay:      5 * time.Second,
+			ShutdownTimeout: 30 * time.Second,
+		},
+		DB: DBConfig{
+			Username:               "yugabyte",
+			Hosts:                  []string{"127.0.0.1:5433", "127.0.0.2:5433", "127.0.0.3:5433"},
+			Database:               "yugabyte",
+			ApplicationName:        "ysql-go-app",
+			PoolName:               "primary",
+			SSLMode:                "disable",
+			StatementTimeout:       15 * time.Second,
+			LoadBalance:            true,
+			TopologyKeys:           []string{"gcp.us-east1.*:1", "gcp.us-central1.*:2", "gcp.us-west1.*:3"},
+			MaxConns:               10,
+			MinConns:               10,
+			MaxConnLifetime:        4 * time.Hour,
+			MaxConnLifetimeJitter:  15 * time.Minute,
+			HealthCheckPeriod:      10 * time.Minute,
+			ConnectTimeout:         5 * time.Second,
+			ReadConsistency:        "bounded-staleness;ms=30000",
+			ConsistencyTokenMargin: 500 * time.Millisecond,
+		},
+		Health: HealthConfig{
+			CacheTTL:                2 * time.Second,
+			CheckTimeout:            2 * time.Second,
+			PoolSaturationThreshold: 0.95,
+			TelemetryErrorWindow:    time.Minute,
+		},
+		OTel: OTelConfig{
+			TracesExporter:        ExporterOTLP,
+			MetricsExporter:       ExporterOTLP,
+			LogsExporter:          ExporterNone,
+			Protocol:              ProtocolGRPC,
+			FilePath:              "telemetry",
+			FileMaxSize:           100,
+			FileMaxBackups:        5,
+			Insecure:              true,
+			Compressor:            "gzip",
+			MetricInterval:        15 * time.Second,
+			HTTPDurationBuckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 7.5, 10},
+			HTTPSizeBuckets:       []float64{0, 128, 512, 1024, 4096, 16384, 65536, 262144, 1048576},
+			Sampler:               SamplerRules,
+			SamplerRatio:          1,
+			SamplerRules:          []string{"* /livez=0", "* /readyz=0"},
+			SampleErrors:          true,
+			SlowThreshold:         time.Second,
+			TracerEnabled:         true,
+			TracerLogSQLStatement: true,
+			TracerIncludeParams:   true,
+			PrefixQuerySpanName:   true,
+		},
+	}
+}
+
+// Load builds the configuration from the defaults, overridden by the YAML file at path (when not empty), then by
+// the environment and finally by the overrides (e.g. command line flags) keyed by environment variable name. A
+// value that cannot be parsed is never silently replaced by its default, every problem is collected and returned
+// joined together, along with the errors of the additional validators for settings owned by other packages.
+func Load(path string, overrides map[string]string, validators ...func(*Config) error) (*Config, error) {
+	cfg := Default()
+	var errs []error
+
+	if path != "" {
+		if err := cfg.readFile(path); err != nil {
+			errs = append(errs, fmt.Errorf("config file %s: %w", path, err))
+		}
+	}
+
+	settings := cfg.settings()
+	for _, s := range settings {
+		if raw, ok := os.LookupEnv(s.env); ok {
+			errs = append(errs, s.set(raw))
+		}
+	}
+
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		errs = append(errs, cfg.Set(name, overrides[name]))
+	}
+
+	// Default leaves the endpoint empty as its default depends on the protocol, which is only known now
+	if cfg.OTel.Endpoint.String() == "" {
+		if endpoint, ok := defaultEndpoints[cfg.OTel.Protocol]; ok {
+			cfg.OTel.Endpoint = MustParseURL(endpoint)
+		}
+	}
+
+	errs = append(errs, cfg.Validate())
+	for _, validate := range validators {
+		errs = append(errs, validate(cfg))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Set parses the value into the setting with the environment variable name
+func (c *Config) Set(name, value string) error {
+	for _, s := range c.settings() {
+		if s.env == name {
+			return s.set(value)
+		}
+	}
+	return fmt.Errorf("unknown setting %s", name)
+}
+
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// Validate reports every setting that is out of range, including those of the enabled modules' sections
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Log.Format == LogFormatText || c.Log.Format == LogFormatJSON, "LOG_FORMAT: '%s' must be text or json", c.Log.Format)
+
+	check(c.Server.Address != "", "SERVER_ADDRESS: must not be empty")
+	check(c.Server.ReadTimeout > 0, "SERVER_READ_TIMEOUT: %s must be positive", c.Server.ReadTimeout)
+	check(c.Server.WriteTimeout > 0, "SERVER_WRITE_TIMEOUT: %s must be positive", c.Server.WriteTimeout)
+
+	check(c.Server.DrainDelay >= 0, "SERVER_DRAIN_DELAY: %s must not be negative", c.Server.DrainDelay)
+	check(c.Server.ShutdownTimeout > c.Server.DrainDelay,
+		"SERVER_SHUTDOWN_TIMEOUT: %s must be longer than SERVER_DRAIN_DELAY (%s)", c.Server.ShutdownTimeout, c.Server.DrainDelay)
+
+	check(len(c.DB.Hosts) > 0, "DB_HOSTNAME: at least one host is required")
+	check(c.DB.Username != "", "DB_USERNAME: must not be empty")
+	check(c.DB.Database != "", "DB_DATABASE: must not be empty")
+	check(c.DB.PoolName != "", "DB_POOL_NAME: must not be empty")
+	check(c.DB.MaxConns >= 1, "DB_MAX_CONNS: %d must be at least 1", c.DB.MaxConns)
+	check(c.DB.MinConns >= 0 && c.DB.MinConns <= c.DB.MaxConns,
+		"DB_MIN_CONNS: %d must be between 0 and DB_MAX_CONNS (%d)", c.DB.MinConns, c.DB.MaxConns)
+	check(c.DB.StatementTimeout > 0, "DB_STATEMENT_TIMEOUT: %s must be positive", c.DB.StatementTimeout)
+	check(c.DB.ConnectTimeout > 0, "DB_CONNECT_TIMEOUT: %s must be positive", c.DB.ConnectTimeout)
+	check(c.DB.HealthCheckPeriod > 0, "DB_HEALTH_CHECK_PERIOD: %s must be positive", c.DB.HealthCheckPeriod)
+	check(c.DB.MaxConnLifetime > 0, "DB_MAX_CONN_LIFETIME: %s must be positive", c.DB.MaxConnLifetime)
+	check(c.DB.MaxConnLifetimeJitter >= 0, "DB_MAX_CONN_LIFETIME_JITTER: %s must not be negative", c.DB.MaxConnLifetimeJitter)
+	check(c.DB.ConsistencyTokenMargin >= 0, "DB_CONSISTENCY_TOKEN_MARGIN: %s must not be negative", c.DB.ConsistencyTokenMargin)
+
+	check(c.Health.CacheTTL >= 0, "HEALTH_CACHE_TTL: %s must not be negative", c.Health.CacheTTL)
+	check(c.Health.CheckTimeout > 0, "HEALTH_CHECK_TIMEOUT: %s must be positive", c.Health.CheckTimeout)
+	check(c.Health.PoolSaturationThreshold > 0 && c.Health.PoolSaturationThreshold <= 1,
+		"HEALTH_POOL_SATURATION_THRESHOLD: %v must be greater than 0 and at most 1", c.Health.PoolSaturationThreshold)
+	check(c.Health.TelemetryErrorWindow > 0, "HEALTH_TELEMETRY_ERROR_WINDOW: %s must be positive", c.Health.TelemetryErrorWindow)
+
+	check(c.Admin.Address == "" || c.Admin.Address != c.Server.Address,
+		"ADMIN_ADDRESS: '%s' must differ from SERVER_ADDRESS", c.Admin.Address)
+	check(c.Admin.Address == "" || c.Admin.Token != "" || IsLoopback(c.Admin.Address),
+		"ADMIN_TOKEN: required when ADMIN_ADDRESS ('%s') is not a loopback address", c.Admin.Address)
+
+	check(slices.Contains(exporters, c.OTel.TracesExporter),
+		"OTEL_TRACES_EXPORTER: '%s' must be one of %s", c.OTel.TracesExporter, strings.Join(exporters, ", "))
+	check(slices.Contains(exporters, c.OTel.MetricsExporter),
+		"OTEL_METRICS_EXPORTER: '%s' must be one of %s", c.OTel.MetricsExporter, strings.Join(exporters, ", "))
+	check(slices.Contains(exporters, c.OTel.LogsExporter),
+		"OTEL_LOGS_EXPORTER: '%s' must be one of %s", c.OTel.LogsExporter, strings.Join(exporters, ", "))
+	check(c.OTel.Protocol == ProtocolGRPC || c.OTel.Protocol == ProtocolHTTPProtobuf,
+		"OTEL_EXPORTER_OTLP_PROTOCOL: '%s' must be grpc or http/protobuf", c.OTel.Protocol)
+	check(c.OTel.FilePath != "", "OTEL_EXPORTER_FILE_PATH: must not be empty")
+	check(c.OTel.FileMaxSize > 0, "OTEL_EXPORTER_FILE_MAX_SIZE: %d must be positive (megabytes)", c.OTel.FileMaxSize)
+	check(c.OTel.FileMaxBackups >= 0, "OTEL_EXPORTER_FILE_MAX_BACKUPS: %d must not be negative", c.OTel.FileMaxBackups)
+	check(!c.OTel.PrometheusEnabled || c.Admin.Address != "",
+		"OTEL_PROMETHEUS_ENABLE: /metrics is served on the admin listener, ADMIN_ADDRESS must be set")
+	check(c.OTel.Endpoint.Host != "" && slices.Contains([]string{"", "http", "https"}, c.OTel.Endpoint.Scheme),
+		"OTEL_EXPORTER_OTLP_ENDPOINT: '%s' must be host:port or an http(s)://host:port URL", c.OTel.Endpoint.String())
+	check(slices.Contains(samplers, c.OTel.Sampler),
+		"OTEL_TRACES_SAMPLER: '%s' must be one of %s", c.OTel.Sampler, strings.Join(samplers, ", "))
+	check(c.OTel.SamplerRatio >= 0 && c.OTel.SamplerRatio <= 1,
+		"OTEL_TRACES_SAMPLER_ARG: %v must be between 0 and 1", c.OTel.SamplerRatio)
+	for _, value := range c.OTel.SamplerRules {
+		_, err := ParseSamplingRule(value)
+		check(err == nil, "OTEL_TRACES_SAMPLER_RULES: %v", err)
+	}
+	check(c.OTel.SlowThreshold >= 0, "OTEL_TRACES_SLOW_THRESHOLD: %s must not be negative", c.OTel.SlowThreshold)
+	check(c.OTel.MetricInterval > 0, "OTEL_METRIC_POLL_INTERVAL: %s must be positive", c.OTel.MetricInterval)
+	check(isAscending(c.OTel.HTTPDurationBuckets),
+		"OTEL_HTTP_DURATION_BUCKETS: %v must be one or more increasing boundaries", c.OTel.HTTPDurationBuckets)
+	check(isAscending(c.OTel.HTTPSizeBuckets),
+		"OTEL_HTTP_SIZE_BUCKETS: %v must be one or more increasing boundaries", c.OTel.HTTPSizeBuckets)
+
+	errs = append(errs, c.Modules.validate()...)
+
+	return errors.Join(errs...)
+}
+
+// isAscending reports whether the histogram bucket boundaries are not empty and strictly increasing
+func isAscending(boundaries []float64) bool {
+	for i := 1; i < len(boundaries); i++ {
+		if boundaries[i] <= boundaries[i-1] {
+			return false
+		}
+	}
+	return len(boundaries) > 0
+}
+
+// IsLoopback reports whether the listen address only accepts local connections, an empty host listens on every
+// interface
+func IsLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil || host == "" {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Masked returns a copy of the configuration with the secrets replaced, safe to print or log
+func (c *Config) Masked() *Config {
+	masked := c.clone()
+	for _, s := range masked.settings() {
+		if s.secret && s.value.String() != "" {
+			s.value.SetString("*****")
+		}
+	}
+	return masked
+}
+
+// clone copies the configuration so the copy's settings can be changed without changing c
+func (c *Config) clone() *Config {
+	cloned := *c
+	cloned.Modules = c.Modules.clone()
+	return &cloned
+}
+
+// WriteYAML writes the configuration, with secrets masked, in the format of the config file
+func (c *Config) WriteYAML(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c.Masked()); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// Environ returns the configuration, with secrets masked, as NAME=value environment variables
+func (c *Config) Environ() []string {
+	settings := c.Masked().settings()
+	environ := make([]string, 0, len(settings))
+	for _, s := range settings {
+		environ = append(environ, s.env+"="+s.format())
+	}
+	return environ
+}
+
+func ErrAttr(err error) slog.Attr {
+	return slog.Any("error", err)
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// ModulesConfig selects the modules that run and holds the configuration section each module declared with
+// RegisterModule, read from modules.<name> in the config file.
+type ModulesConfig struct {
+	// Enabled names the domains the service runs, see the module package
+	Enabled []string `yaml:"enabled" env:"MODULES"`
+	// Sections holds a pointer to each module's configuration by module name
+	Sections map[string]any `yaml:"-"`
+}
+
+var (
+	sectionsMu sync.Mutex
+	sections   = map[string]func() any{}
+)
+
+// RegisterModule declares the configuration section of the named module. defaults returns a pointer to a new
+// struct holding the module's default settings, its fields are tagged like the Config's own (yaml, env, secret and
+// reload). A section with a Validate() error method is validated along with the configuration while its module is
+// enabled.
+func RegisterModule(name string, defaults func() any) {
+	sectionsMu.Lock()
+	defer sectionsMu.Unlock()
+	sections[name] = defaults
+}
+
+// defaultSections returns every registered module's section with its defaults
+func defaultSections() map[string]any {
+	sectionsMu.Lock()
+	defer sectionsMu.Unlock()
+
+	defaults := make(map[string]any, len(sections))
+	for name, section := range sections {
+		defaults[name] = section()
+	}
+	return defaults
+}
+
+// sectionNames returns the names of the sections in a stable order
+func (m ModulesConfig) sectionNames() []string {
+	names := make([]string, 0, len(m.Sections))
+	for name := range m.Sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// clone copies the sections, which are pointers, so the copy can be changed without changing m
+func (m ModulesConfig) clone() ModulesConfig {
+	cloned := m
+	cloned.Sections = make(map[string]any, len(m.Sections))
+	for name, section := range m.Sections {
+		value := reflect.ValueOf(section).Elem()
+		copied := reflect.New(value.Type())
+		copied.Elem().Set(value)
+		cloned.Sections[name] = copied.Interface()
+	}
+	return cloned
+}
+
+// validate reports the problems of the sections of the enabled modules
+func (m ModulesConfig) validate() []error {
+	var errs []error
+	for _, name := range m.Enabled {
+		if section, ok := m.Sections[name].(interface{ Validate() error }); ok {
+			errs = append(errs, section.Validate())
+		}
+	}
+	return errs
+}
+
+func (m *ModulesConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: modules must be a mapping of enabled and the module sections", node.Line)
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.Value == "enabled" {
+			if err := value.Decode(&m.Enabled); err != nil {
+				return err
+			}
+			continue
+		}
+
+		section, ok := m.Sections[key.Value]
+		if !ok {
+			return fmt.Errorf("line %d: no module has a configuration section %s", key.Line, key.Value)
+		}
+		// decoded on its own so unknown keys in the section are still rejected
+		data, err := yaml.Marshal(value)
+		if err != nil {
+			return err
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err = decoder.Decode(section); err != nil {
+			return fmt.Errorf("modules.%s (line %d): %w", key.Value, key.Line, err)
+		}
+	}
+	return nil
+}
+
+func (m ModulesConfig) MarshalYAML() (any, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	add := func(key string, value any) error {
+		var encoded yaml.Node
+		if err := encoded.Encode(value); err != nil {
+			return err
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, &encoded)
+		return nil
+	}
+
+	if err := add("enabled", m.Enabled); err != nil {
+		return nil, err
+	}
+	for _, name := range m.sectionNames() {
+		if err := add(name, m.Sections[name]); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
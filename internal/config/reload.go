@@ -0,0 +1,158 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Change is a setting changed while the service runs
+type Change struct {
+	Setting string `json:"setting"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+}
+
+var (
+	// LogLevel is the level of the default logger, it follows LOG_LEVEL as the configuration changes
+	LogLevel = new(slog.LevelVar)
+
+	current atomic.Pointer[Config]
+
+	// serializes reconfiguration so concurrent reloads cannot lose each other's changes
+	reloadMu sync.Mutex
+)
+
+func init() {
+	current.Store(Default())
+}
+
+// Current returns the configuration in effect. It is swapped as a whole, so code reading reloadable settings
+// (tagged reload:"true") while handling a request should read Current each time rather than keep a copy.
+func Current() *Config {
+	return current.Load()
+}
+
+// SetCurrent publishes the configuration, e.g. once it has been loaded at startup
+func SetCurrent(cfg *Config) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	publish(cfg)
+}
+
+// Apply publishes the reloadable settings of the newly loaded configuration, e.g. after the config file was
+// edited. Settings that need a restart are left as they are and returned as ignored. Every change is audit
+// logged along with its source.
+func Apply(next *Config, source string) (changes []Change, ignored []string) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	updated := Current().clone()
+	nextSettings := next.settings()
+	for i, s := range updated.settings() {
+		from, to := s.format(), nextSettings[i].format()
+		if from == to {
+			continue
+		}
+		if !s.reloadable {
+			ignored = append(ignored, s.env)
+			continue
+		}
+		s.value.Set(nextSettings[i].value)
+		changes = append(changes, s.change(from, to))
+	}
+
+	publish(updated)
+	audit(context.Background(), source, changes, ignored)
+	return changes, ignored
+}
+
+// Update sets reloadable settings by their environment variable name, nothing is changed unless every value is
+// valid. Every change is audit logged along with its source.
+func Update(ctx context.Context, values map[string]string, source string) ([]Change, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	updated := Current().clone()
+	settings := make(map[string]setting)
+	for _, s := range updated.settings() {
+		settings[s.env] = s
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	var changes []Change
+	for _, name := range names {
+		s, ok := settings[name]
+		value := values[name]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("unknown setting %s", name))
+		case !s.reloadable:
+			errs = append(errs, fmt.Errorf("%s: requires a restart to change", name))
+		default:
+			from := s.format()
+			if err := s.set(value); err != nil {
+				errs = append(errs, err)
+			} else if to := s.format(); to != from {
+				changes = append(changes, s.change(from, to))
+			}
+		}
+	}
+	errs = append(errs, updated.Validate())
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	publish(updated)
+	audit(ctx, source, changes, nil)
+	return changes, nil
+}
+
+// Reloadable returns the environment variable names of the settings that can change while the service runs
+func Reloadable() []string {
+	var names []string
+	for _, s := range Current().settings() {
+		if s.reloadable {
+			names = append(names, s.env)
+		}
+	}
+	return names
+}
+
+func publish(cfg *Config) {
+	current.Store(cfg)
+	LogLevel.Set(cfg.Log.Level)
+}
+
+func audit(ctx context.Context, source string, changes []Change, ignored []string) {
+	if len(changes) == 0 && len(ignored) == 0 {
+		return
+	}
+
+	attrs := []any{slog.String("source", source)}
+	for _, change := range changes {
+		attrs = append(attrs, slog.Group(change.Setting, slog.String("from", change.From), slog.String("to", change.To)))
+	}
+	if len(ignored) > 0 {
+		attrs = append(attrs, slog.Any("restart_required", ignored))
+	}
+	slog.InfoContext(ctx, "Configuration changed", attrs...)
+}
+
+func (s setting) change(from, to string) Change {
+	if s.secret {
+		from, to = "*****", "*****"
+	}
+	return Change{Setting: s.env, From: from, To: to}
+}
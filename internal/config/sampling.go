@@ -0,0 +1,79 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// the OTEL_TRACES_SAMPLER values, the standard OTEL ones plus rules
+const (
+	SamplerAlwaysOn                = "always_on"
+	SamplerAlwaysOff               = "always_off"
+	SamplerTraceIDRatio            = "traceidratio"
+	SamplerParentBasedAlwaysOn     = "parentbased_always_on"
+	SamplerParentBasedAlwaysOff    = "parentbased_always_off"
+	SamplerParentBasedTraceIDRatio = "parentbased_traceidratio"
+	// SamplerRules samples root spans by the first of OTEL_TRACES_SAMPLER_RULES matching their method and route,
+	// OTEL_TRACES_SAMPLER_ARG for the others, and child spans as their parent
+	SamplerRules = "rules"
+)
+
+var samplers = []string{
+	SamplerAlwaysOn, SamplerAlwaysOff, SamplerTraceIDRatio,
+	SamplerParentBasedAlwaysOn, SamplerParentBasedAlwaysOff, SamplerParentBasedTraceIDRatio,
+	SamplerRules,
+}
+
+// SamplingRule samples the requests matching its method and route template (either "*" for any) at its ratio, a
+// ratio of 0 never samples them, not even when they fail or are slow
+type SamplingRule struct {
+	Method string  `json:"method"`
+	Route  string  `json:"route"`
+	Ratio  float64 `json:"ratio"`
+}
+
+// ParseSamplingRule parses "[METHOD ]ROUTE=RATIO", e.g. "GET /locations/{id}=0.1", "* /livez=0" or "POST *=1"
+func ParseSamplingRule(value string) (SamplingRule, error) {
+	match, ratioText, found := strings.Cut(value, "=")
+	if !found {
+		return SamplingRule{}, fmt.Errorf("sampling rule '%s' must be [METHOD ]ROUTE=RATIO", value)
+	}
+
+	ratio, err := strconv.ParseFloat(strings.TrimSpace(ratioText), 64)
+	if err != nil || ratio < 0 || ratio > 1 {
+		return SamplingRule{}, fmt.Errorf("sampling rule '%s' ratio must be between 0 and 1", value)
+	}
+
+	rule := SamplingRule{Method: "*", Ratio: ratio}
+	fields := strings.Fields(match)
+	switch len(fields) {
+	case 1:
+		rule.Route = fields[0]
+	case 2:
+		rule.Method, rule.Route = strings.ToUpper(fields[0]), fields[1]
+	default:
+		return SamplingRule{}, fmt.Errorf("sampling rule '%s' must be [METHOD ]ROUTE=RATIO", value)
+	}
+	return rule, nil
+}
+
+// Matches reports whether the rule applies to the request method and route template
+func (r SamplingRule) Matches(method, route string) bool {
+	return (r.Method == "*" || r.Method == method) && (r.Route == "*" || r.Route == route)
+}
+
+func (r SamplingRule) String() string {
+	return fmt.Sprintf("%s %s=%g", r.Method, r.Route, r.Ratio)
+}
+
+// SamplingRules parses OTEL_TRACES_SAMPLER_RULES, Validate has already rejected a configuration with invalid rules
+func (c OTelConfig) SamplingRules() []SamplingRule {
+	rules := make([]SamplingRule, 0, len(c.SamplerRules))
+	for _, value := range c.SamplerRules {
+		if rule, err := ParseSamplingRule(value); err == nil {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
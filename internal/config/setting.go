@@ -0,0 +1,155 @@
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting is a single configuration field along with the environment variable that sets it
+type setting struct {
+	env        string
+	secret     bool
+	reloadable bool
+	value      reflect.Value
+}
+
+// settings walks the configuration sections, followed by the module sections by name, and returns every field
+// tagged with an env name in declaration order
+func (c *Config) settings() []setting {
+	var settings []setting
+
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if env := field.Tag.Get("env"); env != "" {
+				settings = append(settings, setting{
+					env:        env,
+					secret:     field.Tag.Get("secret") == "true",
+					reloadable: field.Tag.Get("reload") == "true",
+					value:      v.Field(i),
+				})
+			} else if field.Type.Kind() == reflect.Struct {
+				walk(v.Field(i))
+			}
+		}
+	}
+	walk(reflect.ValueOf(c).Elem())
+	for _, name := range c.Modules.sectionNames() {
+		walk(reflect.ValueOf(c.Modules.Sections[name]).Elem())
+	}
+
+	return settings
+}
+
+// set parses the text form of the setting: comma separated for slices and key=value pairs for maps
+func (s setting) set(raw string) error {
+	if err := parseValue(s.value, raw); err != nil {
+		if s.secret {
+			raw = "*****"
+		}
+		return fmt.Errorf("%s: '%s' is not a valid %s", s.env, raw, s.value.Type())
+	}
+	return nil
+}
+
+func parseValue(target reflect.Value, raw string) error {
+	if unmarshaler, ok := target.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(raw))
+	}
+
+	if target.Type() == reflect.TypeOf(time.Duration(0)) {
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		target.SetInt(int64(duration))
+		return nil
+	}
+
+	switch target.Kind() {
+	case reflect.String:
+		target.SetString(raw)
+	case reflect.Bool:
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		target.SetBool(value)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		value, err := strconv.ParseInt(strings.TrimSpace(raw), 10, target.Type().Bits())
+		if err != nil {
+			return err
+		}
+		target.SetInt(value)
+	case reflect.Float64:
+		value, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			return err
+		}
+		target.SetFloat(value)
+	case reflect.Slice:
+		values := reflect.MakeSlice(target.Type(), 0, 0)
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value == "" {
+				continue
+			}
+			element := reflect.New(target.Type().Elem()).Elem()
+			if err := parseValue(element, value); err != nil {
+				return err
+			}
+			values = reflect.Append(values, element)
+		}
+		target.Set(values)
+	case reflect.Map:
+		values := map[string]string{}
+		for _, pair := range strings.Split(raw, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			key, value, found := strings.Cut(pair, "=")
+			if !found || strings.TrimSpace(key) == "" {
+				return fmt.Errorf("'%s' is not a key=value pair", pair)
+			}
+			values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+		target.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("unsupported setting type %s", target.Type())
+	}
+
+	return nil
+}
+
+// format returns the text form of the setting, the inverse of set
+func (s setting) format() string {
+	switch value := s.value.Interface().(type) {
+	case []string:
+		return strings.Join(value, ",")
+	case []float64:
+		values := make([]string, len(value))
+		for i, v := range value {
+			values[i] = strconv.FormatFloat(v, 'g', -1, 64)
+		}
+		return strings.Join(values, ",")
+	case map[string]string:
+		pairs := make([]string, 0, len(value))
+		for key, v := range value {
+			pairs = append(pairs, key+"="+v)
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ",")
+	case encoding.TextMarshaler:
+		text, _ := value.MarshalText()
+		return string(text)
+	case fmt.Stringer:
+		return value.String()
+	default:
+		return fmt.Sprint(value)
+	}
+}
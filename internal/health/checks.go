@@ -0,0 +1,56 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"github.com/ssherwood/ysqlapp/internal/migrate"
+	"github.com/ssherwood/ysqlapp/internal/shared"
+	"github.com/yugabyte/pgx/v5/pgxpool"
+	"time"
+)
+
+// DatabaseCheck pings the database through the pool
+func DatabaseCheck(db *pgxpool.Pool) CheckFunc {
+	return func(ctx context.Context) (any, error) {
+		return nil, shared.PingDB(ctx, db)
+	}
+}
+
+// PoolSaturationCheck fails when at least threshold (0-1) of the pool's connections are in use, new requests
+// would mostly be waiting for a connection
+func PoolSaturationCheck(db *pgxpool.Pool, threshold float64) CheckFunc {
+	return func(context.Context) (any, error) {
+		stat := db.Stat()
+		saturation := float64(stat.AcquiredConns()) / float64(stat.MaxConns())
+		detail := map[string]any{
+			"acquired":            stat.AcquiredConns(),
+			"idle":                stat.IdleConns(),
+			"total":               stat.TotalConns(),
+			"max":                 stat.MaxConns(),
+			"empty_acquire_count": stat.EmptyAcquireCount(),
+			"saturation":          saturation,
+		}
+		if saturation >= threshold {
+			return detail, fmt.Errorf("pool saturation %.2f is at or above %.2f", saturation, threshold)
+		}
+		return detail, nil
+	}
+}
+
+// MigrationCheck fails while any migration is pending, the service would be running against an older schema
+func MigrationCheck(migrator *migrate.Migrator) CheckFunc {
+	return func(ctx context.Context) (any, error) {
+		return nil, migrator.RequireCurrent(ctx)
+	}
+}
+
+// TelemetryCheck fails when the OTEL SDK reported an error (e.g. a failed export) within the window
+func TelemetryCheck(window time.Duration) CheckFunc {
+	return func(context.Context) (any, error) {
+		at, err := shared.LastTelemetryError()
+		if err == nil || time.Since(at) > window {
+			return nil, nil
+		}
+		return map[string]any{"last_error_at": at}, err
+	}
+}
@@ -0,0 +1,161 @@
+// Package health serves the liveness and readiness probes and runs the dependency checks behind readiness.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusWarn = "warn"
+	StatusFail = "fail"
+)
+
+// ErrShuttingDown is reported by readiness once Shutdown has started
+var ErrShuttingDown = errors.New("shutting down")
+
+// CheckFunc checks a dependency, the detail is reported whether or not it fails
+type CheckFunc func(ctx context.Context) (detail any, err error)
+
+type check struct {
+	name     string
+	critical bool
+	fn       CheckFunc
+}
+
+// Result is the outcome of one check, a failing check that is not critical is only a warning
+type Result struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Critical bool    `json:"critical"`
+	Error    string  `json:"error,omitempty"`
+	Detail   any     `json:"detail,omitempty"`
+	Duration float64 `json:"duration_ms"`
+}
+
+// Report is the readiness of the service, it is ready when every critical check passes
+type Report struct {
+	Ready     bool      `json:"ready"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+	Checks    []Result  `json:"checks"`
+}
+
+// Checker runs the readiness checks concurrently and caches the report for cacheTTL so frequent probes (from
+// several load balancers) do not turn into a stream of database round trips.
+type Checker struct {
+	checks   []check
+	cacheTTL time.Duration
+	timeout  time.Duration
+
+	shuttingDown atomic.Bool
+
+	mu     sync.Mutex
+	cached *Report
+}
+
+func NewChecker(cacheTTL, timeout time.Duration) *Checker {
+	return &Checker{cacheTTL: cacheTTL, timeout: timeout}
+}
+
+// Add registers a readiness check, a critical check failing makes the service not ready
+func (c *Checker) Add(name string, critical bool, fn CheckFunc) {
+	c.checks = append(c.checks, check{name: name, critical: critical, fn: fn})
+}
+
+// SetShuttingDown makes the service not ready from now on so load balancers stop sending it requests
+func (c *Checker) SetShuttingDown() {
+	c.shuttingDown.Store(true)
+}
+
+// Readiness returns the cached report while it is fresh, otherwise it runs every check
+func (c *Checker) Readiness(ctx context.Context) Report {
+	if c.shuttingDown.Load() {
+		return Report{Ready: false, Error: ErrShuttingDown.Error(), CheckedAt: time.Now(), Checks: []Result{}}
+	}
+
+	// holding the lock while checking means concurrent probes wait for (and share) the one run
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cached != nil && time.Since(c.cached.CheckedAt) < c.cacheTTL {
+		return *c.cached
+	}
+
+	report := c.run(ctx)
+	c.cached = &report
+	return report
+}
+
+func (c *Checker) run(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	report := Report{Ready: true, CheckedAt: time.Now(), Checks: make([]Result, len(c.checks))}
+
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			start := time.Now()
+			detail, err := check.fn(ctx)
+			result := Result{
+				Name:     check.name,
+				Status:   StatusOK,
+				Critical: check.critical,
+				Detail:   detail,
+				Duration: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Error = err.Error()
+				result.Status = StatusWarn
+				if check.critical {
+					result.Status = StatusFail
+				}
+			}
+			report.Checks[i] = result
+		}()
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status == StatusFail {
+			report.Ready = false
+		}
+	}
+	return report
+}
+
+// Register adds /livez and /readyz to the router
+func (c *Checker) Register(r *mux.Router) {
+	r.HandleFunc("/livez", c.Live).Methods("GET")
+	r.HandleFunc("/readyz", c.Ready).Methods("GET")
+}
+
+// Live reports the process is up and serving, it never checks dependencies so a database outage does not get
+// every replica restarted
+func (c *Checker) Live(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": StatusOK})
+}
+
+// Ready reports the readiness checks, 503 Service Unavailable when the service should not receive requests
+func (c *Checker) Ready(w http.ResponseWriter, r *http.Request) {
+	report := c.Readiness(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !report.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,67 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"github.com/ssherwood/ysqlapp/internal/config"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// HTTPServer is a component serving the server. Start binds the listen address, so an address already in use
+// fails the start rather than being logged later, and Stop waits for the in-flight requests.
+func HTTPServer(name string, server *http.Server, stopTimeout time.Duration, dependsOn ...string) Component {
+	return Component{
+		Name:      name,
+		DependsOn: dependsOn,
+		Start: func(ctx context.Context) error {
+			listener, err := net.Listen("tcp", server.Addr)
+			if err != nil {
+				return err
+			}
+
+			slog.InfoContext(ctx, "Starting HTTP server", config.SlogServiceName, slog.String("component", name),
+				slog.String("address", listener.Addr().String()))
+			go func() {
+				if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					slog.ErrorContext(ctx, "HTTP server failed", config.SlogServiceName, slog.String("component", name), config.ErrAttr(err))
+				}
+			}()
+			return nil
+		},
+		Stop:        server.Shutdown,
+		StopTimeout: stopTimeout,
+	}
+}
+
+// Job is a component running a background job until it is stopped, Stop waits for the job to return
+func Job(name string, run func(ctx context.Context), stopTimeout time.Duration, dependsOn ...string) Component {
+	var cancel context.CancelFunc
+	done := make(chan struct{})
+
+	return Component{
+		Name:      name,
+		DependsOn: dependsOn,
+		Start: func(context.Context) error {
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			go func() {
+				defer close(done)
+				run(ctx)
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			cancel()
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+		StopTimeout: stopTimeout,
+	}
+}
@@ -0,0 +1,225 @@
+// Package lifecycle starts the components of the service in dependency order and stops them in reverse, each
+// within its own timeout, reporting how every component stopped.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	StatusStopped  = "stopped"
+	StatusFailed   = "failed"
+	StatusTimedOut = "timed_out"
+)
+
+// Component is a part of the service with a lifecycle. A component without Start is running from the moment it is
+// added (e.g. a pool opened during initialization), a component without Stop has nothing to release.
+type Component struct {
+	Name string
+	// DependsOn names components added earlier, this one starts after and stops before them
+	DependsOn []string
+	Start     func(ctx context.Context) error
+	Stop      func(ctx context.Context) error
+	// StopTimeout bounds Stop, the manager's default applies when it is zero
+	StopTimeout time.Duration
+}
+
+type entry struct {
+	Component
+	level   int
+	running bool
+}
+
+// Manager holds the components in the order they were added
+type Manager struct {
+	stopTimeout time.Duration
+
+	mu         sync.Mutex
+	components []*entry
+	byName     map[string]*entry
+}
+
+func NewManager(stopTimeout time.Duration) *Manager {
+	return &Manager{stopTimeout: stopTimeout, byName: map[string]*entry{}}
+}
+
+// Add registers a component, its dependencies must already be added so the order can never be circular. A
+// duplicate name or unknown dependency is a programming error and panics.
+func (m *Manager) Add(c Component) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.byName[c.Name]; ok {
+		panic(fmt.Sprintf("lifecycle: component %s added twice", c.Name))
+	}
+
+	e := &entry{Component: c, running: c.Start == nil}
+	for _, name := range c.DependsOn {
+		dependency, ok := m.byName[name]
+		if !ok {
+			panic(fmt.Sprintf("lifecycle: component %s depends on %s, which is not added", c.Name, name))
+		}
+		e.level = max(e.level, dependency.level+1)
+	}
+
+	m.components = append(m.components, e)
+	m.byName[c.Name] = e
+}
+
+// Start starts every component after its dependencies. It stops at the first failure, leaving the components
+// already started running for Stop.
+func (m *Manager) Start(ctx context.Context) error {
+	for _, e := range m.ordered() {
+		if e.running {
+			continue
+		}
+
+		start := time.Now()
+		if err := e.Start(ctx); err != nil {
+			return fmt.Errorf("start %s: %w", e.Name, err)
+		}
+		m.mu.Lock()
+		e.running = true
+		m.mu.Unlock()
+		slog.DebugContext(ctx, "Started component", slog.String("component", e.Name), slog.Duration("elapsed", time.Since(start)))
+	}
+	return nil
+}
+
+// Running reports whether the component is started and not yet stopped
+func (m *Manager) Running(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.byName[name]
+	return ok && e.running
+}
+
+// Stop stops the running components in reverse dependency order. Components that do not depend on each other stop
+// concurrently, and one that does not stop within its timeout is given up on so the others still stop.
+func (m *Manager) Stop(ctx context.Context) Summary {
+	summary := Summary{}
+	start := time.Now()
+
+	ordered := m.ordered()
+	for i := len(ordered) - 1; i >= 0; {
+		// the components of one level never depend on each other
+		level := ordered[i].level
+		var batch []*entry
+		for ; i >= 0 && ordered[i].level == level; i-- {
+			m.mu.Lock()
+			running := ordered[i].running
+			ordered[i].running = false
+			m.mu.Unlock()
+			if running {
+				batch = append(batch, ordered[i])
+			}
+		}
+
+		results := make([]Result, len(batch))
+		var wg sync.WaitGroup
+		for j, e := range batch {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[j] = m.stop(ctx, e)
+			}()
+		}
+		wg.Wait()
+		summary.Components = append(summary.Components, results...)
+	}
+
+	summary.Elapsed = time.Since(start)
+	return summary
+}
+
+func (m *Manager) stop(ctx context.Context, e *entry) Result {
+	result := Result{Name: e.Name, Status: StatusStopped}
+	if e.Stop == nil {
+		return result
+	}
+
+	timeout := e.StopTimeout
+	if timeout <= 0 {
+		timeout = m.stopTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- e.Stop(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// a Stop ignoring its context is left behind rather than holding up the rest of the shutdown
+		err = ctx.Err()
+	}
+
+	result.Elapsed = time.Since(start)
+	if err != nil {
+		result.Status = StatusFailed
+		if errors.Is(err, context.DeadlineExceeded) {
+			result.Status = StatusTimedOut
+		}
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// ordered returns the components by level, in the order they were added within a level
+func (m *Manager) ordered() []*entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ordered := append([]*entry(nil), m.components...)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].level < ordered[j].level })
+	return ordered
+}
+
+// Result is how one component stopped
+type Result struct {
+	Name    string
+	Status  string
+	Elapsed time.Duration
+	Error   string
+}
+
+// Summary is how every running component stopped, in the order they were stopped
+type Summary struct {
+	Elapsed    time.Duration
+	Components []Result
+}
+
+// Err joins the errors of the components that failed or timed out
+func (s Summary) Err() error {
+	var errs []error
+	for _, result := range s.Components {
+		if result.Error != "" {
+			errs = append(errs, fmt.Errorf("%s %s: %s", result.Name, result.Status, result.Error))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// LogValue logs the summary as a group per component
+func (s Summary) LogValue() slog.Value {
+	attrs := []slog.Attr{slog.Duration("elapsed", s.Elapsed)}
+	for _, result := range s.Components {
+		componentAttrs := []any{slog.String("status", result.Status), slog.Duration("elapsed", result.Elapsed)}
+		if result.Error != "" {
+			componentAttrs = append(componentAttrs, slog.String("error", result.Error))
+		}
+		attrs = append(attrs, slog.Group(result.Name, componentAttrs...))
+	}
+	return slog.GroupValue(attrs...)
+}
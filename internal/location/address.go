@@ -0,0 +1,200 @@
+package location
+
+import (
+	"github.com/google/uuid"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// AddressMatch describes an existing address that is probably the same as the one being written.
+type AddressMatch struct {
+	AddressId  uuid.UUID `json:"address_id"`
+	Street     string    `json:"street"`
+	City       string    `json:"city"`
+	State      string    `json:"state"`
+	PostalCode string    `json:"postal_code"`
+	Country    string    `json:"country"`
+	Score      float64   `json:"score"`
+}
+
+// streetAbbreviations follows the USPS Publication 28 suffix, directional and secondary unit designators
+var streetAbbreviations = map[string]string{
+	"ALLEY":      "ALY",
+	"APARTMENT":  "APT",
+	"AVENUE":     "AVE",
+	"AV":         "AVE",
+	"BOULEVARD":  "BLVD",
+	"BUILDING":   "BLDG",
+	"CIRCLE":     "CIR",
+	"COURT":      "CT",
+	"DEPARTMENT": "DEPT",
+	"DRIVE":      "DR",
+	"EAST":       "E",
+	"EXPRESSWAY": "EXPY",
+	"FLOOR":      "FL",
+	"HIGHWAY":    "HWY",
+	"LANE":       "LN",
+	"NORTH":      "N",
+	"NORTHEAST":  "NE",
+	"NORTHWEST":  "NW",
+	"PARKWAY":    "PKWY",
+	"PLACE":      "PL",
+	"ROAD":       "RD",
+	"ROOM":       "RM",
+	"SOUTH":      "S",
+	"SOUTHEAST":  "SE",
+	"SOUTHWEST":  "SW",
+	"SQUARE":     "SQ",
+	"STREET":     "ST",
+	"STR":        "ST",
+	"SUITE":      "STE",
+	"TERRACE":    "TER",
+	"TRAIL":      "TRL",
+	"WEST":       "W",
+}
+
+var (
+	whitespacePattern = regexp.MustCompile(`\s+`)
+	unitNumberPattern = regexp.MustCompile(`#\s*(\S+)`)
+	ordinalPattern    = regexp.MustCompile(`^\d+(ST|ND|RD|TH)$`)
+	usZipPattern      = regexp.MustCompile(`^(\d{5})-?(\d{4})?$`)
+	caPostalPattern   = regexp.MustCompile(`^([A-Z]\d[A-Z])\s?(\d[A-Z]\d)$`)
+)
+
+// NormalizeAddress rewrites the address fields of the location in place into their canonical form so that
+// the same street address is always stored (and keyed) identically. The country decides how the postal code is
+// formatted, so it is required rather than guessed.
+func NormalizeAddress(location *Location) error {
+	location.Country = strings.ToUpper(collapseWhitespace(location.Country))
+	if location.Country == "" {
+		return &ValidationError{Field: "country", Message: "is required"}
+	}
+	location.Street = normalizeStreet(location.Street)
+	location.City = titleCase(collapseWhitespace(location.City))
+	location.State = strings.ToUpper(collapseWhitespace(location.State))
+	location.PostalCode = normalizePostalCode(location.PostalCode, location.Country)
+	return nil
+}
+
+// AddressKey returns the normalized key used to find an identical address, the location is expected to have
+// already been through NormalizeAddress.
+func AddressKey(location *Location) string {
+	return strings.ToLower(strings.Join([]string{
+		location.Street, location.City, location.State, location.PostalCode, location.Country,
+	}, "|"))
+}
+
+func normalizeStreet(street string) string {
+	street = unitNumberPattern.ReplaceAllString(street, " UNIT $1 ")
+	street = strings.Map(func(r rune) rune {
+		if r == '.' || r == ',' {
+			return ' '
+		}
+		return r
+	}, street)
+
+	words := strings.Fields(strings.ToUpper(street))
+	for i, word := range words {
+		if abbreviation, ok := streetAbbreviations[word]; ok {
+			words[i] = abbreviation
+		}
+	}
+
+	return titleCase(strings.Join(words, " "))
+}
+
+func normalizePostalCode(postalCode, country string) string {
+	postalCode = strings.ToUpper(collapseWhitespace(postalCode))
+
+	switch country {
+	case "US":
+		if m := usZipPattern.FindStringSubmatch(strings.ReplaceAll(postalCode, " ", "")); m != nil {
+			if m[2] != "" {
+				return m[1] + "-" + m[2]
+			}
+			return m[1]
+		}
+	case "CA":
+		if m := caPostalPattern.FindStringSubmatch(postalCode); m != nil {
+			return m[1] + " " + m[2]
+		}
+	}
+
+	return postalCode
+}
+
+func collapseWhitespace(value string) string {
+	return strings.TrimSpace(whitespacePattern.ReplaceAllString(value, " "))
+}
+
+// titleCase capitalizes the first letter of each word, directionals (NE) and unit numbers (4B) stay upper case
+// and ordinals (5th) lower case
+func titleCase(value string) string {
+	words := strings.Fields(value)
+	for i, word := range words {
+		upper := strings.ToUpper(word)
+		if isDirectional(upper) {
+			words[i] = upper
+			continue
+		}
+		if unicode.IsDigit(rune(word[0])) {
+			if ordinalPattern.MatchString(upper) {
+				words[i] = strings.ToLower(word)
+			} else {
+				words[i] = upper
+			}
+			continue
+		}
+
+		runes := []rune(strings.ToLower(word))
+		if len(runes) > 0 && unicode.IsLetter(runes[0]) {
+			runes[0] = unicode.ToUpper(runes[0])
+		}
+		words[i] = string(runes)
+	}
+	return strings.Join(words, " ")
+}
+
+func isDirectional(word string) bool {
+	switch word {
+	case "N", "S", "E", "W", "NE", "NW", "SE", "SW":
+		return true
+	}
+	return false
+}
+
+// AddressSimilarity scores two normalized address keys between 0 and 1 using trigram overlap (the same measure
+// as pg_trgm's similarity function).
+func AddressSimilarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+
+	trigramsA, trigramsB := trigrams(a), trigrams(b)
+	if len(trigramsA) == 0 || len(trigramsB) == 0 {
+		return 0
+	}
+
+	shared := 0
+	for trigram := range trigramsA {
+		if _, ok := trigramsB[trigram]; ok {
+			shared++
+		}
+	}
+
+	return float64(shared) / float64(len(trigramsA)+len(trigramsB)-shared)
+}
+
+func trigrams(value string) map[string]struct{} {
+	set := make(map[string]struct{})
+	for _, word := range strings.FieldsFunc(value, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = struct{}{}
+		}
+	}
+	return set
+}
@@ -0,0 +1,131 @@
+package location
+
+import "testing"
+
+func TestNormalizeAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		address Location
+		want    Location
+		key     string
+		invalid bool
+	}{
+		{
+			name:    "suffix, unit and zip+4",
+			address: Location{Street: "123  main street, suite 400", City: "  new   york ", State: "ny", PostalCode: "10001 1234", Country: "US"},
+			want:    Location{Street: "123 Main St Ste 400", City: "New York", State: "NY", PostalCode: "10001-1234", Country: "US"},
+			key:     "123 main st ste 400|new york|ny|10001-1234|us",
+		},
+		{
+			name:    "directional, ordinal and unit number",
+			address: Location{Street: "500 northwest 5TH Avenue #4b", City: "PORTLAND", State: "or", PostalCode: "972091234", Country: "us"},
+			want:    Location{Street: "500 NW 5th Ave Unit 4B", City: "Portland", State: "OR", PostalCode: "97209-1234", Country: "US"},
+			key:     "500 nw 5th ave unit 4b|portland|or|97209-1234|us",
+		},
+		{
+			name:    "abbreviations are not expanded",
+			address: Location{Street: "1 NE 1st St.", City: "St. Louis", State: "MO", PostalCode: "63101", Country: "US"},
+			want:    Location{Street: "1 NE 1st St", City: "St. Louis", State: "MO", PostalCode: "63101", Country: "US"},
+			key:     "1 ne 1st st|st. louis|mo|63101|us",
+		},
+		{
+			name:    "canadian postal code",
+			address: Location{Street: "10 King St W", City: "toronto", State: "on", PostalCode: "m5h1a1", Country: "ca"},
+			want:    Location{Street: "10 King St W", City: "Toronto", State: "ON", PostalCode: "M5H 1A1", Country: "CA"},
+			key:     "10 king st w|toronto|on|m5h 1a1|ca",
+		},
+		{
+			name:    "other postal codes are only upper cased",
+			address: Location{Street: "221b baker street", City: "london", State: "en", PostalCode: "nw1  6xe", Country: "gb"},
+			want:    Location{Street: "221B Baker St", City: "London", State: "EN", PostalCode: "NW1 6XE", Country: "GB"},
+			key:     "221b baker st|london|en|nw1 6xe|gb",
+		},
+		{
+			name:    "invalid zip is kept",
+			address: Location{Street: "12 Elm Rd", City: "Springfield", State: "IL", PostalCode: "1234", Country: " us "},
+			want:    Location{Street: "12 Elm Rd", City: "Springfield", State: "IL", PostalCode: "1234", Country: "US"},
+			key:     "12 elm rd|springfield|il|1234|us",
+		},
+		{
+			name:    "missing country",
+			address: Location{Street: "12 Elm Rd", City: "Springfield", State: "IL", PostalCode: "62701", Country: " "},
+			invalid: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.address
+			err := NormalizeAddress(&got)
+			if tt.invalid {
+				if _, ok := err.(*ValidationError); !ok {
+					t.Fatalf("NormalizeAddress() = %v, want a ValidationError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NormalizeAddress() = %v", err)
+			}
+			if got.Street != tt.want.Street || got.City != tt.want.City || got.State != tt.want.State ||
+				got.PostalCode != tt.want.PostalCode || got.Country != tt.want.Country {
+				t.Errorf("NormalizeAddress() = %q, %q, %q, %q, %q, want %q, %q, %q, %q, %q",
+					got.Street, got.City, got.State, got.PostalCode, got.Country,
+					tt.want.Street, tt.want.City, tt.want.State, tt.want.PostalCode, tt.want.Country)
+			}
+			if key := AddressKey(&got); key != tt.key {
+				t.Errorf("AddressKey() = %q, want %q", key, tt.key)
+			}
+
+			again := got
+			_ = NormalizeAddress(&again)
+			if AddressKey(&again) != tt.key {
+				t.Errorf("NormalizeAddress() is not idempotent, %q became %q", tt.key, AddressKey(&again))
+			}
+		})
+	}
+}
+
+func TestAddressSimilarity(t *testing.T) {
+	threshold := Module{}.Config().(*Config).AddressDuplicateThreshold
+
+	key := func(street, city, state, postalCode string) string {
+		address := Location{Street: street, City: city, State: state, PostalCode: postalCode, Country: "US"}
+		_ = NormalizeAddress(&address)
+		return AddressKey(&address)
+	}
+	address := key("123 Main Street", "Springfield", "IL", "62701")
+
+	tests := []struct {
+		name      string
+		other     string
+		duplicate bool
+	}{
+		{"same address written differently", key("123 main st.", "springfield", "il", "62701"), true},
+		{"added suite", key("123 Main St Suite 5", "Springfield", "IL", "62701"), true},
+		{"neighbouring house number", key("125 Main St", "Springfield", "IL", "62701"), true},
+		{"misspelled street", key("123 Maine St", "Springfield", "IL", "62701"), true},
+		{"other street", key("9 Oak Ave", "Springfield", "IL", "62701"), false},
+		{"other city", key("123 Main St", "Chicago", "IL", "60601"), false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score := AddressSimilarity(address, tt.other)
+			if score < 0 || score > 1 {
+				t.Fatalf("AddressSimilarity(%q, %q) = %v, want a score between 0 and 1", address, tt.other, score)
+			}
+			if duplicate := score >= threshold; duplicate != tt.duplicate {
+				t.Errorf("AddressSimilarity(%q, %q) = %v, duplicate at %v = %v, want %v",
+					address, tt.other, score, threshold, duplicate, tt.duplicate)
+			}
+			if reverse := AddressSimilarity(tt.other, address); reverse != score {
+				t.Errorf("AddressSimilarity is not symmetric, %v and %v", score, reverse)
+			}
+		})
+	}
+
+	if score := AddressSimilarity(address, address); score != 1 {
+		t.Errorf("AddressSimilarity of an address with itself = %v, want 1", score)
+	}
+}
@@ -0,0 +1,31 @@
+package location
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Config is the location module's section of the configuration, modules.location in the config file
+type Config struct {
+	AddressDuplicateThreshold float64       `yaml:"address_duplicate_threshold" env:"ADDRESS_DUPLICATE_THRESHOLD"`
+	TransitionInterval        time.Duration `yaml:"transition_interval" env:"LOCATION_TRANSITION_INTERVAL"`
+	TransitionLookback        time.Duration `yaml:"transition_lookback" env:"LOCATION_TRANSITION_LOOKBACK"`
+}
+
+// Validate reports every setting that is out of range
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.AddressDuplicateThreshold >= 0 && c.AddressDuplicateThreshold <= 1,
+		"ADDRESS_DUPLICATE_THRESHOLD: %v must be between 0 and 1", c.AddressDuplicateThreshold)
+	check(c.TransitionInterval >= 0, "LOCATION_TRANSITION_INTERVAL: %s must not be negative", c.TransitionInterval)
+	check(c.TransitionLookback > 0, "LOCATION_TRANSITION_LOOKBACK: %s must be positive", c.TransitionLookback)
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,124 @@
+package location
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/ssherwood/ysqlapp/internal/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusScheduled = "scheduled"
+	StatusActive    = "active"
+	StatusExpired   = "expired"
+	StatusInactive  = "inactive"
+)
+
+const (
+	EventActivated   = "location.activated"
+	EventDeactivated = "location.deactivated"
+)
+
+// StatusAt derives the status of the location at the given time from its effective dates, a location that has
+// been deactivated by hand is inactive from then on regardless of them.
+func (l *Location) StatusAt(t time.Time) string {
+	switch {
+	case !l.Active && (l.DeactivatedAt == nil || !t.Before(*l.DeactivatedAt)):
+		return StatusInactive
+	case l.EffectiveFrom != nil && t.Before(*l.EffectiveFrom):
+		return StatusScheduled
+	case l.EffectiveTo != nil && !t.Before(*l.EffectiveTo):
+		return StatusExpired
+	default:
+		return StatusActive
+	}
+}
+
+// validateEffectiveDates rejects a window that ends before it starts
+func validateEffectiveDates(location *Location) error {
+	if location.EffectiveFrom != nil && location.EffectiveTo != nil && !location.EffectiveFrom.Before(*location.EffectiveTo) {
+		return &ValidationError{Field: "effective_to", Message: "must be after effective_from"}
+	}
+	return nil
+}
+
+// TransitionEvent is emitted when a location becomes effective or stops being effective
+type TransitionEvent struct {
+	ID         uuid.UUID `json:"id"`
+	LocationId uuid.UUID `json:"location_id"`
+	Type       string    `json:"type"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// TransitionJob periodically finds locations whose effective_from or effective_to has passed since it last ran
+// and emits a TransitionEvent for each. Events are recorded in location_event so that, with several replicas
+// running the job, only the replica whose insert wins emits the event.
+type TransitionJob struct {
+	repo     *Repository
+	interval time.Duration
+	lastRun  time.Time
+	events   metric.Int64Counter
+
+	// unix nanoseconds of the last successful run, read by the health check
+	succeededAt atomic.Int64
+}
+
+func NewTransitionJob(repo *Repository, interval, lookback time.Duration, events metric.Int64Counter) *TransitionJob {
+	return &TransitionJob{repo: repo, interval: interval, lastRun: time.Now().Add(-lookback), events: events}
+}
+
+// Run checks for transitions every interval until the context is cancelled
+func (j *TransitionJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		j.RunOnce(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *TransitionJob) RunOnce(ctx context.Context) {
+	now := time.Now()
+	events, err := j.repo.RecordTransitions(ctx, j.lastRun, now)
+	if err != nil {
+		slog.WarnContext(ctx, "Unable to record location transitions", config.ErrAttr(err))
+		return
+	}
+
+	j.lastRun = now
+	j.succeededAt.Store(now.UnixNano())
+	for _, event := range events {
+		j.events.Add(ctx, 1, metric.WithAttributes(attribute.String("event", event.Type)))
+		slog.InfoContext(ctx, "Location transition",
+			slog.String("event", event.Type),
+			slog.String("location_id", event.LocationId.String()),
+			slog.Time("occurred_at", event.OccurredAt))
+	}
+}
+
+// Check warns when the job has not succeeded for three intervals, transitions are only delayed meanwhile so it
+// is not critical
+func (j *TransitionJob) Check(context.Context) (any, error) {
+	succeededAt := j.succeededAt.Load()
+	if succeededAt == 0 {
+		return map[string]any{"succeeded_at": nil}, nil
+	}
+
+	at := time.Unix(0, succeededAt)
+	detail := map[string]any{"succeeded_at": at}
+	if time.Since(at) > 3*j.interval {
+		return detail, fmt.Errorf("no successful run since %s", at.Format(time.RFC3339))
+	}
+	return detail, nil
+}
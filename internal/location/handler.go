@@ -1,30 +1,44 @@
 package location
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/ssherwood/ysqlapp/internal/shared"
 	"github.com/yugabyte/pgx/v5"
-	"github.com/yugabyte/pgx/v5/pgxpool"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Handler struct {
-	db      *pgxpool.Pool
 	service *Service
 }
 
-func NewHandler(r *mux.Router, service *Service, db *pgxpool.Pool) *Handler {
-	handler := &Handler{service: service, db: db}
+func NewHandler(r *mux.Router, service *Service) *Handler {
+	handler := &Handler{service: service}
 	r.HandleFunc("/locations", handler.CreateLocation).Methods("POST")
+	r.HandleFunc("/locations", handler.ListLocations).Methods("GET")
 	r.HandleFunc("/locations/{id}", handler.GetLocation).Methods("GET")
 	r.HandleFunc("/locations/{id}", handler.UpdateLocation).Methods("PUT")
 	r.HandleFunc("/locations/{id}", handler.DeleteLocation).Methods("DELETE")
+	r.HandleFunc("/locations/{id}/children", handler.GetChildren).Methods("GET")
+	r.HandleFunc("/locations/{id}/ancestors", handler.GetAncestors).Methods("GET")
+	r.HandleFunc("/locations/{id}/descendants", handler.GetDescendants).Methods("GET")
+	r.HandleFunc("/locations/{id}/hours", handler.GetOpeningHours).Methods("GET")
+	r.HandleFunc("/locations/{id}/tags/{tag}", handler.AttachTag).Methods("PUT")
+	r.HandleFunc("/locations/{id}/tags/{tag}", handler.DetachTag).Methods("DELETE")
+	r.HandleFunc("/tags", handler.CreateTag).Methods("POST")
+	r.HandleFunc("/tags", handler.ListTags).Methods("GET")
+	r.HandleFunc("/tags/{tag}", handler.GetTag).Methods("GET")
+	r.HandleFunc("/tags/{tag}", handler.UpdateTag).Methods("PUT")
+	r.HandleFunc("/tags/{tag}", handler.DeleteTag).Methods("DELETE")
+	r.HandleFunc("/locations/{id}/hours", handler.ReplaceOpeningHours).Methods("PUT")
 	return handler
 }
 
@@ -37,15 +51,86 @@ func (h *Handler) CreateLocation(w http.ResponseWriter, r *http.Request) {
 
 	newLocation, err := h.service.CreateLocation(r.Context(), &location)
 	if err != nil {
-		// TODO better error handling conditions/types
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
+	shared.SetConsistencyToken(r.Context(), w)
 	w.WriteHeader(http.StatusCreated)
 	_ = json.NewEncoder(w).Encode(newLocation)
 }
 
+func (h *Handler) ListLocations(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := LocationFilter{
+		Query:   query.Get("q"),
+		City:    query.Get("city"),
+		State:   query.Get("state"),
+		Country: query.Get("country"),
+	}
+
+	var err error
+	if value := query.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := query.Get("offset"); value != "" {
+		if filter.Offset, err = strconv.Atoi(value); err != nil {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := query.Get("open_at"); value != "" {
+		openAt, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, "Invalid open_at, expected an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		filter.OpenAt = &openAt
+	} else if openNow, _ := strconv.ParseBool(query.Get("open_now")); openNow {
+		now := time.Now()
+		filter.OpenAt = &now
+	}
+	if value := query.Get("as_of"); value != "" {
+		if filter.AsOf, err = time.Parse(time.RFC3339, value); err != nil {
+			http.Error(w, "Invalid as_of, expected an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+	// tags are compared as normalized, so a repeated or empty tag must not count against tag_match=all
+	for _, tag := range strings.Split(query.Get("tags"), ",") {
+		if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" && !slices.Contains(filter.Tags, tag) {
+			filter.Tags = append(filter.Tags, tag)
+		}
+	}
+	switch query.Get("tag_match") {
+	case "", "any":
+	case "all":
+		filter.MatchAllTags = true
+	default:
+		http.Error(w, "Invalid tag_match, expected any or all", http.StatusBadRequest)
+		return
+	}
+	if value := query.Get("within"); value != "" {
+		within, err := uuid.Parse(value)
+		if err != nil {
+			http.Error(w, "Invalid within location ID", http.StatusBadRequest)
+			return
+		}
+		filter.Within = &within
+	}
+
+	locations, err := h.service.ListLocations(r.Context(), filter)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(locations)
+}
+
 func (h *Handler) GetLocation(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	currentSpan := trace.SpanFromContext(ctx)
@@ -60,7 +145,15 @@ func (h *Handler) GetLocation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	location, err := h.service.GetLocationById(ctx, id)
+	var asOf time.Time
+	if value := r.URL.Query().Get("as_of"); value != "" {
+		if asOf, err = time.Parse(time.RFC3339, value); err != nil {
+			http.Error(w, "Invalid as_of, expected an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+
+	location, err := h.service.GetLocationById(ctx, id, asOf)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			http.Error(w, "Location not found", http.StatusNotFound)
@@ -77,7 +170,7 @@ func (h *Handler) GetLocation(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) UpdateLocation(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+	id, err := uuid.Parse(vars["id"])
 	if err != nil {
 		http.Error(w, "Invalid location ID", http.StatusBadRequest)
 		return
@@ -88,40 +181,257 @@ func (h *Handler) UpdateLocation(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	location.ID = id
 
-	commandTag, err := h.db.Exec(context.Background(),
-		"UPDATE locations SET name=$1, address=$2, city=$3, state=$4, zip_code=$5, country=$6 WHERE id=$7",
-		location.Name, location.Street, location.City, location.State, location.PostalCode, location.Country, id)
+	updatedLocation, err := h.service.UpdateLocation(r.Context(), &location)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
-	if commandTag.RowsAffected() == 0 {
-		http.Error(w, "Location not found", http.StatusNotFound)
+
+	shared.SetConsistencyToken(r.Context(), w)
+	json.NewEncoder(w).Encode(updatedLocation)
+}
+
+func (h *Handler) GetOpeningHours(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid location ID", http.StatusBadRequest)
 		return
 	}
 
-	json.NewEncoder(w).Encode(location)
+	hours, err := h.service.GetOpeningHours(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(hours)
+}
+
+func (h *Handler) ReplaceOpeningHours(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid location ID", http.StatusBadRequest)
+		return
+	}
+
+	var hours OpeningHours
+	if err := json.NewDecoder(r.Body).Decode(&hours); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hours.LocationId = id
+
+	updatedHours, err := h.service.ReplaceOpeningHours(r.Context(), &hours)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	shared.SetConsistencyToken(r.Context(), w)
+	json.NewEncoder(w).Encode(updatedHours)
 }
 
+// DeleteLocation deactivates the location, ?mode=cascade deactivates its descendants too while the default
+// ?mode=block refuses with 409 Conflict when it still has active children
 func (h *Handler) DeleteLocation(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid location ID", http.StatusBadRequest)
+		return
+	}
+
+	var cascade bool
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "", "block":
+	case "cascade":
+		cascade = true
+	default:
+		http.Error(w, "Invalid mode, expected block or cascade", http.StatusBadRequest)
+		return
+	}
+
+	if err = h.service.DeactivateLocation(r.Context(), id, cascade); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	shared.SetConsistencyToken(r.Context(), w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) GetChildren(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid location ID", http.StatusBadRequest)
+		return
+	}
+
+	children, err := h.service.GetChildren(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(children)
+}
+
+func (h *Handler) GetAncestors(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid location ID", http.StatusBadRequest)
+		return
+	}
+
+	ancestors, err := h.service.GetAncestors(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(ancestors)
+}
+
+func (h *Handler) GetDescendants(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid location ID", http.StatusBadRequest)
+		return
+	}
+
+	var maxDepth int
+	if value := r.URL.Query().Get("max_depth"); value != "" {
+		if maxDepth, err = strconv.Atoi(value); err != nil {
+			http.Error(w, "Invalid max_depth", http.StatusBadRequest)
+			return
+		}
+	}
+
+	descendants, err := h.service.GetDescendants(r.Context(), id, maxDepth)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(descendants)
+}
+
+func (h *Handler) CreateTag(w http.ResponseWriter, r *http.Request) {
+	var tag Tag
+	if err := json.NewDecoder(r.Body).Decode(&tag); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	newTag, err := h.service.CreateTag(r.Context(), &tag)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	shared.SetConsistencyToken(r.Context(), w)
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(newTag)
+}
+
+func (h *Handler) ListTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := h.service.ListTags(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(tags)
+}
+
+func (h *Handler) GetTag(w http.ResponseWriter, r *http.Request) {
+	tag, err := h.service.GetTag(r.Context(), mux.Vars(r)["tag"])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(tag)
+}
+
+func (h *Handler) UpdateTag(w http.ResponseWriter, r *http.Request) {
+	var tag Tag
+	if err := json.NewDecoder(r.Body).Decode(&tag); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tag.Name = mux.Vars(r)["tag"]
+
+	updatedTag, err := h.service.UpdateTag(r.Context(), &tag)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	shared.SetConsistencyToken(r.Context(), w)
+	json.NewEncoder(w).Encode(updatedTag)
+}
+
+func (h *Handler) DeleteTag(w http.ResponseWriter, r *http.Request) {
+	if err := h.service.DeleteTag(r.Context(), mux.Vars(r)["tag"]); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	shared.SetConsistencyToken(r.Context(), w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) AttachTag(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+	id, err := uuid.Parse(vars["id"])
 	if err != nil {
 		http.Error(w, "Invalid location ID", http.StatusBadRequest)
 		return
 	}
 
-	commandTag, err := h.db.Exec(context.Background(),
-		"DELETE FROM locations WHERE id=$1", id)
+	if err = h.service.AttachTag(r.Context(), id, vars["tag"]); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	shared.SetConsistencyToken(r.Context(), w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) DetachTag(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := uuid.Parse(vars["id"])
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Invalid location ID", http.StatusBadRequest)
 		return
 	}
-	if commandTag.RowsAffected() == 0 {
-		http.Error(w, "Location not found", http.StatusNotFound)
+
+	if err = h.service.DetachTag(r.Context(), id, vars["tag"]); err != nil {
+		writeError(w, err)
 		return
 	}
 
+	shared.SetConsistencyToken(r.Context(), w)
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// writeError maps service errors onto HTTP status codes
+func writeError(w http.ResponseWriter, err error) {
+	var validationErr *ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		http.Error(w, validationErr.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrParentCycle):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrHasChildren), errors.Is(err, ErrTagExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrTagNotFound):
+		http.Error(w, "Tag not found", http.StatusNotFound)
+	case errors.Is(err, pgx.ErrNoRows):
+		http.Error(w, "Location not found", http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,211 @@
+package location
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/yugabyte/pgx/v5/pgtype"
+	"strings"
+	"time"
+)
+
+const (
+	clockLayout = "15:04"
+	dateLayout  = "2006-01-02"
+
+	day  = 24 * time.Hour
+	week = 7 * day
+)
+
+// weekdays are indexed by ISO day of week (1=monday ... 7=sunday), the same numbering as postgres' isodow
+var weekdays = []string{"", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"}
+
+// OpeningHours is the weekly schedule of a location plus dated exceptions that override it (holidays, temporary
+// closures, special hours). All times are wall clock times in the location's TimeZone.
+type OpeningHours struct {
+	LocationId uuid.UUID        `json:"location_id"`
+	TimeZone   string           `json:"time_zone"`
+	Weekly     []DailyHours     `json:"weekly"`
+	Exceptions []HoursException `json:"exceptions"`
+	OpenNow    bool             `json:"open_now"`
+}
+
+// DailyHours is a single opening interval on a day of the week, a day may have several (e.g. a lunch closure).
+// When Closes is at or before Opens the interval runs overnight into the next day.
+type DailyHours struct {
+	Day    string `json:"day"`
+	Opens  string `json:"opens"`
+	Closes string `json:"closes"`
+}
+
+// HoursException replaces the weekly hours for a single date, either closing the location for the day or
+// opening it between Opens and Closes.
+type HoursException struct {
+	Date   string `json:"date"`
+	Closed bool   `json:"closed"`
+	Opens  string `json:"opens,omitempty"`
+	Closes string `json:"closes,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Validate normalizes the time zone, day names, times and dates and reports the first problem found.
+func (h *OpeningHours) Validate() error {
+	if h.TimeZone == "" {
+		h.TimeZone = "UTC"
+	}
+	if _, err := time.LoadLocation(h.TimeZone); err != nil {
+		return &ValidationError{Field: "time_zone", Message: err.Error()}
+	}
+
+	for i := range h.Weekly {
+		hours := &h.Weekly[i]
+		hours.Day = strings.ToLower(strings.TrimSpace(hours.Day))
+		if isoWeekday(hours.Day) == 0 {
+			return &ValidationError{Field: fmt.Sprintf("weekly[%d].day", i), Message: "must be a day of the week"}
+		}
+		if _, err := parseClock(hours.Opens); err != nil {
+			return &ValidationError{Field: fmt.Sprintf("weekly[%d].opens", i), Message: err.Error()}
+		}
+		if _, err := parseClock(hours.Closes); err != nil {
+			return &ValidationError{Field: fmt.Sprintf("weekly[%d].closes", i), Message: err.Error()}
+		}
+		for j := 0; j < i; j++ {
+			if weekInterval(h.Weekly[j]).overlaps(weekInterval(*hours)) {
+				return &ValidationError{Field: fmt.Sprintf("weekly[%d]", i), Message: fmt.Sprintf("overlaps weekly[%d]", j)}
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i := range h.Exceptions {
+		exception := &h.Exceptions[i]
+		if _, err := time.Parse(dateLayout, exception.Date); err != nil {
+			return &ValidationError{Field: fmt.Sprintf("exceptions[%d].date", i), Message: "must be formatted as YYYY-MM-DD"}
+		}
+		if seen[exception.Date] {
+			return &ValidationError{Field: fmt.Sprintf("exceptions[%d].date", i), Message: "only one exception per date"}
+		}
+		seen[exception.Date] = true
+
+		if exception.Closed {
+			exception.Opens, exception.Closes = "", ""
+			continue
+		}
+		if _, err := parseClock(exception.Opens); err != nil {
+			return &ValidationError{Field: fmt.Sprintf("exceptions[%d].opens", i), Message: err.Error()}
+		}
+		if _, err := parseClock(exception.Closes); err != nil {
+			return &ValidationError{Field: fmt.Sprintf("exceptions[%d].closes", i), Message: err.Error()}
+		}
+	}
+
+	return nil
+}
+
+// IsOpenAt applies the same rules as the open_at list filter: the location is open inside any interval of the local
+// date, or one running overnight from the previous date. An exception replaces the weekly intervals of its date, so
+// it also decides what runs overnight into the next date. The hours are expected to have been validated.
+func (h *OpeningHours) IsOpenAt(t time.Time) bool {
+	zone, err := time.LoadLocation(h.TimeZone)
+	if err != nil {
+		return false
+	}
+
+	local := t.In(zone)
+	clock := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute
+
+	for _, hours := range h.intervalsOn(local) {
+		opens, _ := parseClock(hours.Opens)
+		closes, _ := parseClock(hours.Closes)
+		if opens <= clock && (clock < closes || closes <= opens) {
+			return true
+		}
+	}
+	for _, hours := range h.intervalsOn(local.AddDate(0, 0, -1)) {
+		opens, _ := parseClock(hours.Opens)
+		closes, _ := parseClock(hours.Closes)
+		if closes <= opens && clock < closes {
+			return true
+		}
+	}
+
+	return false
+}
+
+// intervalsOn returns the intervals starting on the local date, the exception's when the date has one
+func (h *OpeningHours) intervalsOn(local time.Time) []DailyHours {
+	date := local.Format(dateLayout)
+	for _, exception := range h.Exceptions {
+		if exception.Date == date {
+			if exception.Closed {
+				return nil
+			}
+			return []DailyHours{{Opens: exception.Opens, Closes: exception.Closes}}
+		}
+	}
+
+	today := (int(local.Weekday())+6)%7 + 1
+	var intervals []DailyHours
+	for _, hours := range h.Weekly {
+		if isoWeekday(hours.Day) == today {
+			intervals = append(intervals, hours)
+		}
+	}
+	return intervals
+}
+
+// interval is a span of the week measured from monday 00:00, an overnight interval on sunday ends past the week
+type interval struct {
+	start, end time.Duration
+}
+
+// weekInterval places validated weekly hours in the week, closing at or before opening runs into the next day
+func weekInterval(hours DailyHours) interval {
+	opens, _ := parseClock(hours.Opens)
+	closes, _ := parseClock(hours.Closes)
+	if closes <= opens {
+		closes += day
+	}
+	start := time.Duration(isoWeekday(hours.Day)-1)*day + opens
+	return interval{start: start, end: start + closes - opens}
+}
+
+// overlaps is true when the intervals share any time, including sunday night running into monday
+func (i interval) overlaps(other interval) bool {
+	for _, shift := range []time.Duration{-week, 0, week} {
+		if i.start+shift < other.end && other.start < i.end+shift {
+			return true
+		}
+	}
+	return false
+}
+
+func isoWeekday(day string) int {
+	for i := 1; i < len(weekdays); i++ {
+		if weekdays[i] == day {
+			return i
+		}
+	}
+	return 0
+}
+
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse(clockLayout, value)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' must be formatted as HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// pgTime converts a validated HH:MM clock value into a postgres time
+func pgTime(value string) pgtype.Time {
+	clock, _ := parseClock(value)
+	return pgtype.Time{Microseconds: clock.Microseconds(), Valid: true}
+}
+
+// clockString formats a postgres time as HH:MM, or an empty string when null
+func clockString(value pgtype.Time) string {
+	if !value.Valid {
+		return ""
+	}
+	return time.UnixMicro(value.Microseconds).UTC().Format(clockLayout)
+}
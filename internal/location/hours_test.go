@@ -0,0 +1,104 @@
+package location
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOpeningHoursIsOpenAt(t *testing.T) {
+	hours := OpeningHours{
+		TimeZone: "America/New_York",
+		Weekly: []DailyHours{
+			{Day: "friday", Opens: "22:00", Closes: "02:00"},
+			{Day: "saturday", Opens: "09:00", Closes: "17:00"},
+			{Day: "sunday", Opens: "20:00", Closes: "00:00"},
+		},
+		Exceptions: []HoursException{
+			{Date: "2026-10-23", Closed: true, Reason: "private event"},
+			{Date: "2026-10-28", Opens: "21:00", Closes: "01:00", Reason: "late opening"},
+			{Date: "2026-10-31", Opens: "12:00", Closes: "16:00", Reason: "short day"},
+		},
+	}
+	if err := hours.Validate(); err != nil {
+		t.Fatalf("Validate() = %v", err)
+	}
+
+	local := func(value string) time.Time {
+		zone, _ := time.LoadLocation(hours.TimeZone)
+		at, err := time.ParseInLocation("2006-01-02 15:04", value, zone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return at
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		open bool
+	}{
+		{"friday before opening", local("2026-10-16 21:59"), false},
+		{"friday at opening", local("2026-10-16 22:00"), true},
+		{"friday before midnight", local("2026-10-16 23:59"), true},
+		{"saturday at midnight", local("2026-10-17 00:00"), true},
+		{"saturday after midnight", local("2026-10-17 01:59"), true},
+		{"saturday at closing", local("2026-10-17 02:00"), false},
+		{"saturday between intervals", local("2026-10-17 08:59"), false},
+		{"saturday daytime", local("2026-10-17 12:00"), true},
+		{"saturday at daytime closing", local("2026-10-17 17:00"), false},
+		{"sunday closing at midnight", local("2026-10-18 23:59"), true},
+		{"monday at midnight", local("2026-10-19 00:00"), false},
+		{"thursday before an overnight friday", local("2026-10-15 23:00"), false},
+		{"utc instant on friday night in the time zone", time.Date(2026, 10, 17, 3, 0, 0, 0, time.UTC), true},
+		{"utc instant after closing in the time zone", time.Date(2026, 10, 17, 6, 30, 0, 0, time.UTC), false},
+		{"closed exception on friday", local("2026-10-23 23:00"), false},
+		{"closed exception on friday is not carried into saturday", local("2026-10-24 01:00"), false},
+		{"friday overnight carried into a saturday exception", local("2026-10-31 01:00"), true},
+		{"saturday exception replaces the weekly hours", local("2026-10-31 10:00"), false},
+		{"saturday exception hours", local("2026-10-31 15:59"), true},
+		{"overnight exception before midnight", local("2026-10-28 23:30"), true},
+		{"overnight exception carried into the next date", local("2026-10-29 00:30"), true},
+		{"overnight exception at closing", local("2026-10-29 01:00"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if open := hours.IsOpenAt(tt.at); open != tt.open {
+				t.Errorf("IsOpenAt(%s) = %v, want %v", tt.at.Format(time.RFC3339), open, tt.open)
+			}
+		})
+	}
+}
+
+func TestOpeningHoursValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		weekly []DailyHours
+		field  string
+	}{
+		{"lunch closure", []DailyHours{{Day: "monday", Opens: "09:00", Closes: "12:00"}, {Day: "monday", Opens: "13:00", Closes: "17:00"}}, ""},
+		{"adjacent", []DailyHours{{Day: "monday", Opens: "09:00", Closes: "12:00"}, {Day: "monday", Opens: "12:00", Closes: "17:00"}}, ""},
+		{"overnight before the next day opens", []DailyHours{{Day: "monday", Opens: "22:00", Closes: "02:00"}, {Day: "tuesday", Opens: "02:00", Closes: "05:00"}}, ""},
+		{"duplicate", []DailyHours{{Day: "monday", Opens: "09:00", Closes: "17:00"}, {Day: " Monday", Opens: "09:00", Closes: "12:00"}}, "weekly[1]"},
+		{"overlapping", []DailyHours{{Day: "monday", Opens: "09:00", Closes: "13:00"}, {Day: "monday", Opens: "12:00", Closes: "17:00"}}, "weekly[1]"},
+		{"overnight into the next day", []DailyHours{{Day: "monday", Opens: "22:00", Closes: "02:00"}, {Day: "tuesday", Opens: "01:00", Closes: "05:00"}}, "weekly[1]"},
+		{"sunday overnight into monday", []DailyHours{{Day: "monday", Opens: "00:00", Closes: "08:00"}, {Day: "sunday", Opens: "20:00", Closes: "01:00"}}, "weekly[1]"},
+		{"all day", []DailyHours{{Day: "friday", Opens: "00:00", Closes: "00:00"}, {Day: "friday", Opens: "12:00", Closes: "13:00"}}, "weekly[1]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hours := OpeningHours{TimeZone: "UTC", Weekly: tt.weekly}
+			err := hours.Validate()
+			if tt.field == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if validationErr, ok := err.(*ValidationError); !ok || validationErr.Field != tt.field {
+				t.Errorf("Validate() = %v, want a ValidationError of %s", err, tt.field)
+			}
+		})
+	}
+}
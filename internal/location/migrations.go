@@ -0,0 +1,76 @@
+package location
+
+import (
+	"context"
+	"embed"
+	"github.com/google/uuid"
+	"github.com/yugabyte/pgx/v5"
+	"log/slog"
+)
+
+// Migrations holds the versioned schema of the location domain, it is applied by the migrate package
+//
+//go:embed migrations/*.sql
+var Migrations embed.FS
+
+// addressKeyUniqueVersion is the migration making normalized_key unique, the existing addresses are keyed first
+const addressKeyUniqueVersion = 20261018000008
+
+// backfillAddressKeys normalizes and keys every address, merging those with the same key into the oldest one (the
+// others' locations are repointed to it and they are deleted) so that the key can be made unique. An address that
+// cannot be normalized (no country) is left without a key.
+func backfillAddressKeys(ctx context.Context, tx pgx.Tx) error {
+	rows, err := tx.Query(ctx,
+		`select id, street, city, state_cd, postal_cd, country_cd
+               from address
+              order by modified_at, id`)
+	if err != nil {
+		return err
+	}
+	addresses, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Location, error) {
+		var address Location
+		err := row.Scan(&address.AddressId, &address.Street, &address.City, &address.State, &address.PostalCode, &address.Country)
+		return address, err
+	})
+	if err != nil {
+		return err
+	}
+
+	var batch pgx.Batch
+	kept := make(map[string]uuid.UUID, len(addresses))
+	merged, unkeyed := 0, 0
+	for i := range addresses {
+		address := &addresses[i]
+		if err = NormalizeAddress(address); err != nil {
+			slog.WarnContext(ctx, "Unable to key an existing address", slog.String("address_id", address.AddressId.String()),
+				slog.String("reason", err.Error()))
+			unkeyed++
+			continue
+		}
+
+		key := AddressKey(address)
+		if keep, ok := kept[key]; ok {
+			batch.Queue(`update location set address_id=$1 where address_id=$2`, keep, address.AddressId)
+			batch.Queue(`delete from address where id=$1`, address.AddressId)
+			merged++
+			continue
+		}
+		kept[key] = address.AddressId
+
+		batch.Queue(
+			`update address
+                set street=$2, city=$3, state_cd=$4, postal_cd=$5, country_cd=$6, normalized_key=$7
+              where id=$1`,
+			address.AddressId, address.Street, address.City, address.State, address.PostalCode, address.Country, key)
+	}
+
+	if batch.Len() > 0 {
+		if err = tx.SendBatch(ctx, &batch).Close(); err != nil {
+			return err
+		}
+	}
+
+	slog.InfoContext(ctx, "Keyed the existing addresses", slog.Int("keyed", len(kept)), slog.Int("merged", merged),
+		slog.Int("unkeyed", unkeyed))
+	return nil
+}
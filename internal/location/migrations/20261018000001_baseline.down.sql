@@ -0,0 +1,2 @@
+drop table if exists location;
+drop table if exists address;
@@ -0,0 +1,30 @@
+-- the schema as it was created by hand before migrations, "if not exists" adopts a database that already has it
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+
+create table if not exists address
+(
+    id          uuid primary key     default uuid_generate_v4(),
+    version     int                  default 1,
+    street      text        not null,
+    city        text        not null,
+    state_cd    varchar(2)  not null,
+    postal_cd   text        not null,
+    country_cd  varchar(2)  not null default 'US',
+    longitude   float       not null default 39.509444,
+    latitude    float       not null default -98.433056,
+    modified_by text        not null default current_user,
+    modified_at timestamptz not null default current_timestamp
+) split into 5 tablets;
+
+create table if not exists location
+(
+    id          uuid primary key     default uuid_generate_v4(),
+    version     int                  default 1,
+    name        text        not null,
+    description text,
+    address_id  uuid references address (id),
+    metadata    jsonb       not null default '{}',
+    active      bool        not null default true,
+    modified_by text        not null default current_user,
+    modified_at timestamptz not null default current_timestamp
+) split into 5 tablets;
@@ -0,0 +1,2 @@
+drop index if exists address_postal_cd_idx;
+alter table address drop column if exists normalized_key;
@@ -0,0 +1,4 @@
+alter table address add column if not exists normalized_key text;
+
+-- the key is made unique by 20261018000008 once the existing rows have been keyed and their duplicates merged
+create index if not exists address_postal_cd_idx on address (country_cd, postal_cd);
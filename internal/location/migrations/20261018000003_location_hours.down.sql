@@ -0,0 +1,3 @@
+drop table if exists location_hours_exception;
+drop table if exists location_hours;
+alter table location drop column if exists time_zone;
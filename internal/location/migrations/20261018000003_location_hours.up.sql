@@ -0,0 +1,21 @@
+alter table location add column if not exists time_zone text not null default 'UTC';
+
+create table if not exists location_hours
+(
+    location_id uuid     not null references location (id),
+    day_of_week smallint not null check (day_of_week between 1 and 7),
+    opens       time     not null,
+    closes      time     not null,
+    primary key (location_id, day_of_week, opens)
+);
+
+create table if not exists location_hours_exception
+(
+    location_id    uuid not null references location (id),
+    exception_date date not null,
+    closed         bool not null default true,
+    opens          time,
+    closes         time,
+    reason         text,
+    primary key (location_id, exception_date)
+);
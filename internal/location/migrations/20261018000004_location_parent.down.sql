@@ -0,0 +1,2 @@
+drop index if exists location_parent_id_idx;
+alter table location drop column if exists parent_id;
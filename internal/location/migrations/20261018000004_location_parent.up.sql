@@ -0,0 +1,3 @@
+alter table location add column if not exists parent_id uuid references location (id);
+
+create index if not exists location_parent_id_idx on location (parent_id);
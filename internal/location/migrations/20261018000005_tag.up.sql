@@ -0,0 +1,16 @@
+create table if not exists tag
+(
+    name        text primary key,
+    description text,
+    created_at  timestamptz not null default current_timestamp
+);
+
+create table if not exists location_tag
+(
+    location_id uuid not null references location (id),
+    tag         text not null references tag (name),
+    primary key (location_id hash, tag)
+) split into 5 tablets;
+
+-- tag filters drive from the tag so the hash-split index locates matching locations without a full scan
+create index if not exists location_tag_tag_idx on location_tag (tag hash, location_id asc);
@@ -0,0 +1,5 @@
+drop table if exists location_event;
+drop index if exists location_effective_to_idx;
+drop index if exists location_effective_from_idx;
+alter table location drop column if exists effective_to;
+alter table location drop column if exists effective_from;
@@ -0,0 +1,15 @@
+alter table location add column if not exists effective_from timestamptz;
+alter table location add column if not exists effective_to timestamptz;
+
+create index if not exists location_effective_from_idx on location (effective_from asc);
+create index if not exists location_effective_to_idx on location (effective_to asc);
+
+create table if not exists location_event
+(
+    id          uuid primary key     default uuid_generate_v4(),
+    location_id uuid        not null references location (id),
+    event_type  text        not null,
+    occurred_at timestamptz not null,
+    created_at  timestamptz not null default current_timestamp,
+    unique (location_id, event_type, occurred_at)
+);
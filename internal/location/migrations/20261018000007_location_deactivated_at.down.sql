@@ -0,0 +1 @@
+alter table location drop column if exists deactivated_at;
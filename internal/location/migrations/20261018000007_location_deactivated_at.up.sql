@@ -0,0 +1,4 @@
+alter table location add column if not exists deactivated_at timestamptz;
+
+-- a location is never modified once deactivated, so its last modification is when it was deactivated
+update location set deactivated_at = modified_at where active = false and deactivated_at is null;
@@ -0,0 +1,2 @@
+-- the merged duplicate addresses are not restored
+drop index if exists address_normalized_key_idx;
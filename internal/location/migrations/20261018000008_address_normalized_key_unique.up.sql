@@ -0,0 +1,3 @@
+-- the existing addresses are normalized, keyed and their duplicates merged in Go (backfillAddressKeys) before this
+-- runs, unique so concurrent writes of the same new address upsert the one row
+create unique index if not exists address_normalized_key_idx on address (normalized_key);
@@ -1,17 +1,66 @@
 package location
 
-import "github.com/google/uuid"
+import (
+	"github.com/google/uuid"
+	"time"
+)
 
 type Location struct {
-	ID          uuid.UUID `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	AddressId   uuid.UUID `json:"address_id"`
-	Street      string    `json:"street"`
-	City        string    `json:"city"`
-	State       string    `json:"state"`
-	PostalCode  string    `json:"postal_code"`
-	Country     string    `json:"country"`
-	Longitude   float64   `json:"longitude"`
-	Latitude    float64   `json:"latitude"`
+	ID          uuid.UUID  `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	ParentId    *uuid.UUID `json:"parent_id,omitempty"`
+	AddressId   uuid.UUID  `json:"address_id"`
+	Street      string     `json:"street"`
+	City        string     `json:"city"`
+	State       string     `json:"state"`
+	PostalCode  string     `json:"postal_code"`
+	Country     string     `json:"country"`
+	Longitude   float64    `json:"longitude"`
+	Latitude    float64    `json:"latitude"`
+	TimeZone    string     `json:"time_zone"`
+	Depth       int        `json:"depth,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	Active      bool       `json:"active"`
+	Status      string     `json:"status"`
+
+	EffectiveFrom *time.Time `json:"effective_from,omitempty"`
+	EffectiveTo   *time.Time `json:"effective_to,omitempty"`
+	DeactivatedAt *time.Time `json:"deactivated_at,omitempty"`
+
+	ProbableDuplicates []AddressMatch `json:"probable_duplicates,omitempty"`
+}
+
+// LocationFilter narrows the locations returned by a list/search, zero values are not applied.
+type LocationFilter struct {
+	Query   string
+	City    string
+	State   string
+	Country string
+	OpenAt  *time.Time
+	Within  *uuid.UUID
+	Tags    []string
+	AsOf    time.Time
+	Limit   int
+	Offset  int
+
+	// MatchAllTags requires a location to have every one of the Tags rather than any of them
+	MatchAllTags bool
+}
+
+// Tag is a lightweight label attached to any number of locations
+type Tag struct {
+	Name          string `json:"name"`
+	Description   string `json:"description"`
+	LocationCount int64  `json:"location_count"`
+}
+
+// ValidationError reports a request field that failed validation, handlers map it to a 400 Bad Request.
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Field + ": " + e.Message
 }
@@ -0,0 +1,60 @@
+package location
+
+import (
+	"context"
+	"github.com/ssherwood/ysqlapp/internal/migrate"
+	"github.com/ssherwood/ysqlapp/internal/module"
+	"go.opentelemetry.io/otel/metric"
+	"io/fs"
+	"time"
+)
+
+func init() {
+	module.Register(Module{})
+}
+
+// Module plugs the location domain (locations, addresses, hours and tags) into the service
+type Module struct{}
+
+func (Module) Name() string {
+	return "location"
+}
+
+func (Module) Migrations() fs.FS {
+	return Migrations
+}
+
+func (Module) DataMigrations() map[int64]migrate.DataFunc {
+	return map[int64]migrate.DataFunc{addressKeyUniqueVersion: backfillAddressKeys}
+}
+
+func (Module) Config() any {
+	return &Config{
+		AddressDuplicateThreshold: 0.8,
+		TransitionInterval:        time.Minute,
+		TransitionLookback:        24 * time.Hour,
+	}
+}
+
+func (Module) Init(_ context.Context, host *module.Host) error {
+	cfg := host.ModuleConfig().(*Config)
+
+	repository := NewRepository(host.DB)
+	service := NewService(repository, *cfg)
+	_ = NewHandler(host.Router, service)
+
+	if cfg.TransitionInterval > 0 {
+		events, err := host.Meter.Int64Counter("location.transitions",
+			metric.WithDescription("The number of location transition events emitted"),
+			metric.WithUnit("{event}"))
+		if err != nil {
+			return err
+		}
+
+		job := NewTransitionJob(repository, cfg.TransitionInterval, cfg.TransitionLookback, events)
+		host.AddJob("transitions", job.Run, 0)
+		host.AddCheck("transitions", false, job.Check)
+	}
+
+	return nil
+}
@@ -2,81 +2,755 @@ package location
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
+	"github.com/ssherwood/ysqlapp/internal/shared"
 	"github.com/yugabyte/pgx/v5"
+	"github.com/yugabyte/pgx/v5/pgtype"
 	"github.com/yugabyte/pgx/v5/pgxpool"
-	"log/slog"
+	"strings"
 	"time"
 )
 
+// locationColumns is the select list read by scanLocation, queries alias location as loc and address as adr
+const locationColumns = `loc.id, loc.name, loc.description, loc.parent_id, loc.time_zone, loc.active, loc.deactivated_at, loc.effective_from, loc.effective_to, adr.id, adr.street, adr.city, adr.state_cd, adr.postal_cd, adr.country_cd, adr.longitude, adr.latitude,
+       array(select lt.tag from location_tag lt where lt.location_id = loc.id order by lt.tag)`
+
+// activeAsOfCondition keeps the locations that were not yet deactivated at the time of its placeholder, so a read as
+// of a past time still returns a location deactivated since
+const activeAsOfCondition = "(loc.active=true or %[1]s < loc.deactivated_at)"
+
+// ErrHasChildren is returned when deactivating a location that still has active children without cascading
+var ErrHasChildren = errors.New("location has active children")
+
+// ErrTagNotFound is returned when a tag does not exist
+var ErrTagNotFound = errors.New("tag not found")
+
+// ErrTagExists is returned when creating a tag whose name is already taken
+var ErrTagExists = errors.New("tag already exists")
+
+// ErrParentCycle is returned when the requested parent is the location itself or one of its descendants
+var ErrParentCycle = errors.New("parent would create a cycle in the location hierarchy")
+
+// maxHierarchyDepth bounds the recursive hierarchy queries, campus > building > floor > room is nowhere near it
+const maxHierarchyDepth = 32
+
 type Repository struct {
-	db *pgxpool.Pool
+	db *shared.MeteredPool
 }
 
 func NewRepository(db *pgxpool.Pool) *Repository {
-	return &Repository{db: db}
+	return &Repository{db: &shared.MeteredPool{Pool: db}}
 }
 
 func (r *Repository) CreateLocation(ctx context.Context, location *Location) (*Location, error) {
-	var newLocation Location
-	err := r.db.QueryRow(ctx,
-		`INSERT INTO address (street, city, state_cd, postal_cd, country_cd)
-                  VALUES ($1, $2, $3, $4, $5)
-               RETURNING id, latitude, longitude`,
-		location.Street, location.City, location.State, location.PostalCode, location.Country).
-		Scan(&newLocation.AddressId, &newLocation.Latitude, &newLocation.Longitude)
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	newLocation := *location
+	if err = resolveAddress(ctx, tx, &newLocation); err != nil {
+		return nil, err
+	}
+
+	if err = checkParent(ctx, tx, uuid.Nil, newLocation.ParentId); err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRow(ctx,
+		`INSERT INTO location (name, description, address_id, parent_id, effective_from, effective_to)
+                  VALUES ($1, $2, $3, $4, $5, $6)
+               RETURNING id, active, time_zone`,
+		newLocation.Name, newLocation.Description, newLocation.AddressId, newLocation.ParentId, newLocation.EffectiveFrom, newLocation.EffectiveTo).
+		Scan(&newLocation.ID, &newLocation.Active, &newLocation.TimeZone)
 	if err != nil {
 		return nil, err
 	}
 
+	if err = shared.Commit(ctx, tx); err != nil {
+		return nil, err
+	}
+
 	return &newLocation, nil
 }
 
-func (r *Repository) GetLocationById(ctx context.Context, id uuid.UUID) (*Location, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+func (r *Repository) UpdateLocation(ctx context.Context, location *Location) (*Location, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
 
-	conn, err := r.db.Acquire(ctx)
+	updatedLocation := *location
+	if err = resolveAddress(ctx, tx, &updatedLocation); err != nil {
+		return nil, err
+	}
+
+	if err = checkParent(ctx, tx, updatedLocation.ID, updatedLocation.ParentId); err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRow(ctx,
+		`UPDATE location
+               SET name=$1, description=$2, address_id=$3, parent_id=$4, effective_from=$5, effective_to=$6, version=version+1, modified_at=current_timestamp
+             WHERE id=$7
+               AND active=true
+         RETURNING active, time_zone`,
+		updatedLocation.Name, updatedLocation.Description, updatedLocation.AddressId, updatedLocation.ParentId, updatedLocation.EffectiveFrom, updatedLocation.EffectiveTo, updatedLocation.ID).
+		Scan(&updatedLocation.Active, &updatedLocation.TimeZone)
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Release()
 
-	// enable yb_read_from_follower BEFORE the BEGIN TX (we'll reset it too at the end)
-	_, _ = conn.Exec(ctx, "set yb_read_from_followers = true")
+	if err = shared.Commit(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	return &updatedLocation, nil
+}
 
-	//_, _ = conn.Exec(ctx, "SELECT pg_sleep(30)")
+// checkParent verifies that the parent is an existing, active location and, when re-parenting an existing location,
+// that the location is not the parent itself or one of the parent's ancestors.
+func checkParent(ctx context.Context, tx pgx.Tx, id uuid.UUID, parentId *uuid.UUID) error {
+	if parentId == nil {
+		return nil
+	}
 
-	// now we can BEGIN TRANSACTION READ ONLY
-	tx, err := conn.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	var parentActive, cycle bool
+	err := tx.QueryRow(ctx,
+		`with recursive ancestors(id, parent_id) as (
+                  select id, parent_id
+                    from location
+                   where id=$1
+               union
+                  select parent.id, parent.parent_id
+                    from location parent
+                    join ancestors child
+                      on parent.id = child.parent_id
+             )
+             select coalesce((select active from location where id=$1), false),
+                    exists (select 1 from ancestors where id=$2)`, *parentId, id).
+		Scan(&parentActive, &cycle)
+	if err != nil {
+		return err
+	}
+
+	if !parentActive {
+		return &ValidationError{Field: "parent_id", Message: "must be an active location"}
+	}
+	if cycle {
+		return ErrParentCycle
+	}
+
+	return nil
+}
+
+// resolveAddress reuses the address row with the same normalized key as the location, only inserting a new
+// address when there is no exact match. The location's address fields are expected to already be normalized. The
+// key is unique, so concurrent writes of the same new address resolve to the one row rather than racing to insert.
+func resolveAddress(ctx context.Context, tx pgx.Tx, location *Location) error {
+	return tx.QueryRow(ctx,
+		`INSERT INTO address (street, city, state_cd, postal_cd, country_cd, normalized_key)
+                  VALUES ($1, $2, $3, $4, $5, $6)
+                      ON CONFLICT (normalized_key) DO UPDATE SET normalized_key=excluded.normalized_key
+               RETURNING id, latitude, longitude`,
+		location.Street, location.City, location.State, location.PostalCode, location.Country, AddressKey(location)).
+		Scan(&location.AddressId, &location.Latitude, &location.Longitude)
+}
+
+// FindAddressesByPostalCode returns the addresses sharing a postal code, these are the candidates checked for
+// probable duplicates of a newly written address.
+func (r *Repository) FindAddressesByPostalCode(ctx context.Context, country, postalCode string) ([]AddressMatch, error) {
+	rows, err := r.db.Query(ctx,
+		`select id, street, city, state_cd, postal_cd, country_cd
+               from address
+              where country_cd=$1
+                and postal_cd=$2
+              limit 100`, country, postalCode)
 	if err != nil {
 		return nil, err
 	}
 
-	var value string
-	_ = tx.QueryRow(ctx, "select current_setting('yb_read_from_followers')").Scan(&value)
-	slog.Debug("Running in Tx", slog.String("yb_read_from_followers", value))
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (AddressMatch, error) {
+		var match AddressMatch
+		err := row.Scan(&match.AddressId, &match.Street, &match.City, &match.State, &match.PostalCode, &match.Country)
+		return match, err
+	})
+}
+
+// GetLocationById returns the location unless it had been deactivated by asOf
+func (r *Repository) GetLocationById(ctx context.Context, id uuid.UUID, asOf time.Time) (*Location, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := shared.BeginReadOnly(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	// read-only, so there is never anything to commit
+	defer func() { _ = tx.Rollback(ctx) }()
 
 	var location Location
-	err = tx.QueryRow(ctx,
-		`select loc.id, loc.name, loc.description, adr.id, adr.street, adr.city, adr.state_cd, adr.postal_cd, adr.country_cd, adr.longitude, adr.latitude
+	err = scanLocation(tx.QueryRow(ctx,
+		`select `+locationColumns+`
                from location loc
           left join address adr
                  on loc.address_id = adr.id
               where loc.id=$1
-                and loc.active=true`, id).
-		Scan(&location.ID, &location.Name, &location.Description, &location.AddressId, &location.Street, &location.City, &location.State, &location.PostalCode, &location.Country, &location.Longitude, &location.Latitude)
+                and `+fmt.Sprintf(activeAsOfCondition, "$2"), id, asOf), &location)
+	if err != nil {
+		return nil, err
+	}
+
+	return &location, nil
+}
 
+func (r *Repository) CreateTag(ctx context.Context, tag *Tag) error {
+	commandTag, err := shared.ExecCommit(ctx, r.db,
+		`INSERT INTO tag (name, description) VALUES ($1, $2) ON CONFLICT (name) DO NOTHING`,
+		tag.Name, tag.Description)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() == 0 {
+		return ErrTagExists
+	}
+	return nil
+}
+
+func (r *Repository) UpdateTag(ctx context.Context, tag *Tag) error {
+	commandTag, err := shared.ExecCommit(ctx, r.db, `UPDATE tag SET description=$1 WHERE name=$2`, tag.Description, tag.Name)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() == 0 {
+		return ErrTagNotFound
+	}
+	return nil
+}
+
+// DeleteTag removes the tag, detaching it from every location
+func (r *Repository) DeleteTag(ctx context.Context, name string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err = tx.Exec(ctx, `DELETE FROM location_tag WHERE tag=$1`, name); err != nil {
+		return err
+	}
+	commandTag, err := tx.Exec(ctx, `DELETE FROM tag WHERE name=$1`, name)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() == 0 {
+		return ErrTagNotFound
+	}
+
+	return shared.Commit(ctx, tx)
+}
+
+// ListTags returns the tags with the number of active locations carrying each, a non-empty name limits it to
+// that one tag
+func (r *Repository) ListTags(ctx context.Context, name string) ([]Tag, error) {
+	tx, err := shared.BeginReadOnly(ctx, r.db)
 	if err != nil {
-		_ = tx.Rollback(ctx)
-		_, _ = conn.Exec(ctx, "set yb_read_from_followers = false")
 		return nil, err
 	}
+	// read-only, so there is never anything to commit
+	defer func() { _ = tx.Rollback(ctx) }()
 
-	_ = tx.Commit(ctx)
-	_, _ = conn.Exec(ctx, "set yb_read_from_followers = false")
+	rows, err := tx.Query(ctx,
+		`select t.name, coalesce(t.description, ''), count(loc.id)
+               from tag t
+          left join location_tag lt
+                 on lt.tag = t.name
+          left join location loc
+                 on loc.id = lt.location_id
+                and `+fmt.Sprintf(activeAsOfCondition, "current_timestamp")+`
+              where $1 = '' or t.name = $1
+           group by t.name, t.description
+           order by t.name`, name)
+	if err != nil {
+		return nil, err
+	}
 
-	return &location, nil
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Tag, error) {
+		var tag Tag
+		err := row.Scan(&tag.Name, &tag.Description, &tag.LocationCount)
+		return tag, err
+	})
+}
+
+// AttachTag labels an active location with the tag, creating the tag the first time it is used
+func (r *Repository) AttachTag(ctx context.Context, locationId uuid.UUID, name string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var active bool
+	if err = tx.QueryRow(ctx, `select active from location where id=$1`, locationId).Scan(&active); err != nil {
+		return err
+	}
+	if !active {
+		return pgx.ErrNoRows
+	}
+
+	if _, err = tx.Exec(ctx, `INSERT INTO tag (name) VALUES ($1) ON CONFLICT (name) DO NOTHING`, name); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx,
+		`INSERT INTO location_tag (location_id, tag) VALUES ($1, $2) ON CONFLICT (location_id, tag) DO NOTHING`,
+		locationId, name)
+	if err != nil {
+		return err
+	}
+
+	return shared.Commit(ctx, tx)
+}
+
+func (r *Repository) DetachTag(ctx context.Context, locationId uuid.UUID, name string) error {
+	commandTag, err := shared.ExecCommit(ctx, r.db, `DELETE FROM location_tag WHERE location_id=$1 AND tag=$2`, locationId, name)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() == 0 {
+		return ErrTagNotFound
+	}
+	return nil
+}
+
+// RecordTransitions records an event for every active location whose effective_from or effective_to falls in
+// (since, until], returning only the events this call inserted (those already recorded by an earlier run or
+// another replica are skipped).
+func (r *Repository) RecordTransitions(ctx context.Context, since, until time.Time) ([]TransitionEvent, error) {
+	rows, err := r.db.Query(ctx,
+		`with transitions(location_id, event_type, occurred_at) as (
+                  select id, $3, effective_from
+                    from location
+                   where active=true
+                     and effective_from > $1
+                     and effective_from <= $2
+               union all
+                  select id, $4, effective_to
+                    from location
+                   where active=true
+                     and effective_to > $1
+                     and effective_to <= $2
+             )
+             INSERT INTO location_event (location_id, event_type, occurred_at)
+             SELECT location_id, event_type, occurred_at FROM transitions
+             ON CONFLICT (location_id, event_type, occurred_at) DO NOTHING
+             RETURNING id, location_id, event_type, occurred_at`,
+		since, until, EventActivated, EventDeactivated)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (TransitionEvent, error) {
+		var event TransitionEvent
+		err := row.Scan(&event.ID, &event.LocationId, &event.Type, &event.OccurredAt)
+		return event, err
+	})
+}
+
+// openAtCondition is true when a location is open at the timestamp parameter (formatted into %[1]s), it mirrors
+// OpeningHours.IsOpenAt: any interval starting on the local date or running overnight from the previous date, where
+// an exception replaces the weekly intervals of its date.
+const openAtCondition = `exists (
+    select 1
+      from (select %[1]s::timestamptz at time zone loc.time_zone as ts) lt
+cross join lateral (values (lt.ts::date, false), (lt.ts::date - 1, true)) d(local_date, previous)
+cross join lateral (select ex.opens, ex.closes
+                      from location_hours_exception ex
+                     where ex.location_id = loc.id
+                       and ex.exception_date = d.local_date
+                       and not ex.closed
+                 union all
+                    select h.opens, h.closes
+                      from location_hours h
+                     where h.location_id = loc.id
+                       and h.day_of_week = extract(isodow from d.local_date)
+                       and not exists (select 1
+                                         from location_hours_exception ex
+                                        where ex.location_id = loc.id
+                                          and ex.exception_date = d.local_date)) i
+     where case
+             when d.previous then i.closes <= i.opens and lt.ts::time < i.closes
+             else i.opens <= lt.ts::time and (lt.ts::time < i.closes or i.closes <= i.opens)
+           end)`
+
+// withinCondition limits a query to the subtree rooted at the location parameter (formatted into %[1]s),
+// including the root itself
+const withinCondition = `loc.id in (
+    with recursive subtree(id, depth) as (
+          select %[1]s::uuid, 0
+       union all
+          select child.id, s.depth + 1
+            from location child
+            join subtree s
+              on child.parent_id = s.id
+           where s.depth < %[2]s
+    )
+    select id from subtree)`
+
+// locationQuery accumulates the where conditions and positional arguments of a filtered location query
+type locationQuery struct {
+	conditions []string
+	args       []any
+}
+
+// arg adds a positional argument to the query and returns its placeholder
+func (q *locationQuery) arg(value any) string {
+	q.args = append(q.args, value)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+func (q *locationQuery) where(condition string) {
+	q.conditions = append(q.conditions, condition)
+}
+
+func (r *Repository) ListLocations(ctx context.Context, filter LocationFilter) ([]Location, error) {
+	query := &locationQuery{}
+	asOf := query.arg(filter.AsOf)
+	query.where(fmt.Sprintf(activeAsOfCondition, asOf))
+	if filter.Query != "" {
+		pattern := query.arg("%" + filter.Query + "%")
+		query.where(fmt.Sprintf("(loc.name ilike %[1]s or loc.description ilike %[1]s)", pattern))
+	}
+	if filter.City != "" {
+		query.where("adr.city=" + query.arg(filter.City))
+	}
+	if filter.State != "" {
+		query.where("adr.state_cd=" + query.arg(filter.State))
+	}
+	if filter.Country != "" {
+		query.where("adr.country_cd=" + query.arg(filter.Country))
+	}
+	if filter.OpenAt != nil {
+		query.where(fmt.Sprintf(openAtCondition, query.arg(*filter.OpenAt)))
+	}
+	query.where(fmt.Sprintf("(loc.effective_from is null or loc.effective_from <= %[1]s)", asOf))
+	query.where(fmt.Sprintf("(loc.effective_to is null or %[1]s < loc.effective_to)", asOf))
+	if len(filter.Tags) > 0 {
+		tags := query.arg(filter.Tags)
+		if filter.MatchAllTags {
+			query.where(fmt.Sprintf(`loc.id in (
+    select location_id
+      from location_tag
+     where tag = any(%[1]s)
+  group by location_id
+    having count(*) = cardinality(%[1]s::text[]))`, tags))
+		} else {
+			query.where(fmt.Sprintf(`loc.id in (select location_id from location_tag where tag = any(%s))`, tags))
+		}
+	}
+	if filter.Within != nil {
+		query.where(fmt.Sprintf(withinCondition, query.arg(*filter.Within), query.arg(maxHierarchyDepth)))
+	}
+
+	tx, err := shared.BeginReadOnly(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	// read-only, so there is never anything to commit
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	rows, err := tx.Query(ctx,
+		`select `+locationColumns+`
+               from location loc
+          left join address adr
+                 on loc.address_id = adr.id
+              where `+strings.Join(query.conditions, "\n                and ")+`
+           order by loc.name, loc.id
+              limit `+query.arg(filter.Limit)+` offset `+query.arg(filter.Offset),
+		query.args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Location, error) {
+		var location Location
+		err := scanLocation(row, &location)
+		return location, err
+	})
+}
+
+func scanLocation(row pgx.Row, location *Location) error {
+	return row.Scan(locationFields(location)...)
+}
+
+// locationFields are the scan destinations matching locationColumns
+func locationFields(location *Location) []any {
+	return []any{&location.ID, &location.Name, &location.Description, &location.ParentId, &location.TimeZone, &location.Active, &location.DeactivatedAt, &location.EffectiveFrom, &location.EffectiveTo, &location.AddressId, &location.Street, &location.City, &location.State, &location.PostalCode, &location.Country, &location.Longitude, &location.Latitude, &location.Tags}
+}
+
+// GetChildren returns the active locations directly under the parent
+func (r *Repository) GetChildren(ctx context.Context, parentId uuid.UUID) ([]Location, error) {
+	tx, err := shared.BeginReadOnly(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	// read-only, so there is never anything to commit
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	rows, err := tx.Query(ctx,
+		`select `+locationColumns+`
+               from location loc
+          left join address adr
+                 on loc.address_id = adr.id
+              where loc.parent_id=$1
+                and `+fmt.Sprintf(activeAsOfCondition, "current_timestamp")+`
+           order by loc.name, loc.id`, parentId)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Location, error) {
+		var location Location
+		err := scanLocation(row, &location)
+		location.Depth = 1
+		return location, err
+	})
+}
+
+// GetAncestors walks up the hierarchy from the location, returning the parent first and the root last
+func (r *Repository) GetAncestors(ctx context.Context, id uuid.UUID) ([]Location, error) {
+	tx, err := shared.BeginReadOnly(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	// read-only, so there is never anything to commit
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	rows, err := tx.Query(ctx,
+		`with recursive ancestors(id, parent_id, depth) as (
+                  select parent.id, parent.parent_id, 1
+                    from location child
+                    join location parent
+                      on parent.id = child.parent_id
+                   where child.id=$1
+               union all
+                  select parent.id, parent.parent_id, a.depth + 1
+                    from location parent
+                    join ancestors a
+                      on parent.id = a.parent_id
+                   where a.depth < $2
+             )
+             select `+locationColumns+`, a.depth
+               from ancestors a
+               join location loc
+                 on loc.id = a.id
+          left join address adr
+                 on loc.address_id = adr.id
+           order by a.depth`, id, maxHierarchyDepth)
+	if err != nil {
+		return nil, err
+	}
+
+	return collectLocationsWithDepth(rows)
+}
+
+// GetDescendants walks down the hierarchy from the location breadth first, at most maxDepth levels
+func (r *Repository) GetDescendants(ctx context.Context, id uuid.UUID, maxDepth int) ([]Location, error) {
+	tx, err := shared.BeginReadOnly(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	// read-only, so there is never anything to commit
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	rows, err := tx.Query(ctx,
+		`with recursive descendants(id, depth) as (
+                  select id, 0
+                    from location
+                   where id=$1
+               union all
+                  select child.id, d.depth + 1
+                    from location child
+                    join descendants d
+                      on child.parent_id = d.id
+                   where child.active=true
+                     and d.depth < $2
+             )
+             select `+locationColumns+`, d.depth
+               from descendants d
+               join location loc
+                 on loc.id = d.id
+          left join address adr
+                 on loc.address_id = adr.id
+              where d.depth > 0
+           order by d.depth, loc.name, loc.id`, id, maxDepth)
+	if err != nil {
+		return nil, err
+	}
+
+	return collectLocationsWithDepth(rows)
+}
+
+func collectLocationsWithDepth(rows pgx.Rows) ([]Location, error) {
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Location, error) {
+		var location Location
+		err := row.Scan(append(locationFields(&location), &location.Depth)...)
+		return location, err
+	})
+}
+
+// DeactivateLocation marks the location inactive. With cascade its active descendants are deactivated in the same
+// transaction, otherwise ErrHasChildren is returned if there are any.
+func (r *Repository) DeactivateLocation(ctx context.Context, id uuid.UUID, cascade bool) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if !cascade {
+		var hasChildren bool
+		err = tx.QueryRow(ctx, `select exists (select 1 from location where parent_id=$1 and active=true)`, id).
+			Scan(&hasChildren)
+		if err != nil {
+			return err
+		}
+		if hasChildren {
+			return ErrHasChildren
+		}
+	}
+
+	commandTag, err := tx.Exec(ctx,
+		`with recursive subtree(id, depth) as (
+                  select id, 0
+                    from location
+                   where id=$1
+                     and active=true
+               union all
+                  select child.id, s.depth + 1
+                    from location child
+                    join subtree s
+                      on child.parent_id = s.id
+                   where child.active=true
+                     and s.depth < $2
+             )
+             UPDATE location
+                SET active=false, deactivated_at=current_timestamp, version=version+1, modified_at=current_timestamp
+              WHERE id in (select id from subtree)`, id, maxHierarchyDepth)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return shared.Commit(ctx, tx)
+}
+
+func (r *Repository) GetOpeningHours(ctx context.Context, locationId uuid.UUID) (*OpeningHours, error) {
+	tx, err := shared.BeginReadOnly(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	// read-only, so there is never anything to commit
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	hours := OpeningHours{LocationId: locationId, Weekly: []DailyHours{}, Exceptions: []HoursException{}}
+	err = tx.QueryRow(ctx, `select time_zone from location where id=$1 and active=true`, locationId).
+		Scan(&hours.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(ctx,
+		`select day_of_week, opens, closes
+               from location_hours
+              where location_id=$1
+           order by day_of_week, opens`, locationId)
+	if err != nil {
+		return nil, err
+	}
+	hours.Weekly, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (DailyHours, error) {
+		var day int
+		var opens, closes pgtype.Time
+		err := row.Scan(&day, &opens, &closes)
+		return DailyHours{Day: weekdays[day], Opens: clockString(opens), Closes: clockString(closes)}, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.Query(ctx,
+		`select exception_date, closed, opens, closes, coalesce(reason, '')
+               from location_hours_exception
+              where location_id=$1
+           order by exception_date`, locationId)
+	if err != nil {
+		return nil, err
+	}
+	hours.Exceptions, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (HoursException, error) {
+		var exception HoursException
+		var date time.Time
+		var opens, closes pgtype.Time
+		err := row.Scan(&date, &exception.Closed, &opens, &closes, &exception.Reason)
+		exception.Date, exception.Opens, exception.Closes = date.Format(dateLayout), clockString(opens), clockString(closes)
+		return exception, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &hours, nil
+}
+
+// ReplaceOpeningHours overwrites the time zone, weekly hours and exceptions of a location in one transaction.
+func (r *Repository) ReplaceOpeningHours(ctx context.Context, hours *OpeningHours) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	commandTag, err := tx.Exec(ctx,
+		`UPDATE location SET time_zone=$1, modified_at=current_timestamp WHERE id=$2 AND active=true`,
+		hours.TimeZone, hours.LocationId)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	if _, err = tx.Exec(ctx, `DELETE FROM location_hours WHERE location_id=$1`, hours.LocationId); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx, `DELETE FROM location_hours_exception WHERE location_id=$1`, hours.LocationId); err != nil {
+		return err
+	}
+
+	batch := &pgx.Batch{}
+	for _, daily := range hours.Weekly {
+		batch.Queue(`INSERT INTO location_hours (location_id, day_of_week, opens, closes) VALUES ($1, $2, $3, $4)`,
+			hours.LocationId, isoWeekday(daily.Day), pgTime(daily.Opens), pgTime(daily.Closes))
+	}
+	for _, exception := range hours.Exceptions {
+		date, _ := time.Parse(dateLayout, exception.Date)
+		var opens, closes pgtype.Time
+		if !exception.Closed {
+			opens, closes = pgTime(exception.Opens), pgTime(exception.Closes)
+		}
+		batch.Queue(`INSERT INTO location_hours_exception (location_id, exception_date, closed, opens, closes, reason)
+                          VALUES ($1, $2, $3, $4, $5, nullif($6, ''))`,
+			hours.LocationId, date, exception.Closed, opens, closes, exception.Reason)
+	}
+	if err = tx.SendBatch(ctx, batch).Close(); err != nil {
+		return err
+	}
+
+	return shared.Commit(ctx, tx)
 }
 
 // return &Location{
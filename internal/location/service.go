@@ -3,21 +3,291 @@ package location
 import (
 	"context"
 	"github.com/google/uuid"
+	"github.com/ssherwood/ysqlapp/internal/config"
+	"github.com/ssherwood/ysqlapp/internal/shared"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 )
 
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// tagPattern keeps tags short, lower case slugs such as flagship, 24h or pilot-2026
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._:-]{0,62}$`)
+
 type Service struct {
 	repo *Repository
+
+	// addresses scoring at or above this similarity are reported as probable duplicates
+	duplicateThreshold float64
 }
 
-func NewService(repo *Repository) *Service {
-	return &Service{repo: repo}
+func NewService(repo *Repository, cfg Config) *Service {
+	return &Service{repo: repo, duplicateThreshold: cfg.AddressDuplicateThreshold}
 }
 
 func (s *Service) CreateLocation(ctx context.Context, location *Location) (*Location, error) {
 	// TODO validity checks for required fields, etc
-	return s.repo.CreateLocation(ctx, location)
+	if err := validateEffectiveDates(location); err != nil {
+		return nil, err
+	}
+	if err := NormalizeAddress(location); err != nil {
+		return nil, err
+	}
+
+	newLocation, err := s.repo.CreateLocation(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+
+	newLocation.Status = newLocation.StatusAt(time.Now())
+	newLocation.ProbableDuplicates = s.probableDuplicates(ctx, newLocation)
+	return newLocation, nil
+}
+
+func (s *Service) UpdateLocation(ctx context.Context, location *Location) (*Location, error) {
+	if err := validateEffectiveDates(location); err != nil {
+		return nil, err
+	}
+	if err := NormalizeAddress(location); err != nil {
+		return nil, err
+	}
+
+	updatedLocation, err := s.repo.UpdateLocation(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+
+	updatedLocation.Status = updatedLocation.StatusAt(time.Now())
+	updatedLocation.ProbableDuplicates = s.probableDuplicates(ctx, updatedLocation)
+	return updatedLocation, nil
+}
+
+// probableDuplicates scores the other addresses in the same postal code against the location's address and
+// reports those at or above the configured similarity threshold, best match first.
+func (s *Service) probableDuplicates(ctx context.Context, location *Location) []AddressMatch {
+	candidates, err := s.repo.FindAddressesByPostalCode(ctx, location.Country, location.PostalCode)
+	if err != nil {
+		// the write has already succeeded, so only warn that the duplicate check could not be done
+		slog.WarnContext(ctx, "Unable to check for duplicate addresses", config.ErrAttr(err))
+		return nil
+	}
+
+	key := AddressKey(location)
+	var matches []AddressMatch
+	for _, candidate := range candidates {
+		if candidate.AddressId == location.AddressId {
+			continue
+		}
+
+		candidate.Score = AddressSimilarity(key, AddressKey(&Location{
+			Street:     candidate.Street,
+			City:       candidate.City,
+			State:      candidate.State,
+			PostalCode: candidate.PostalCode,
+			Country:    candidate.Country,
+		}))
+		if candidate.Score >= s.duplicateThreshold {
+			matches = append(matches, candidate)
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+	return matches
+}
+
+// ListLocations applies the default page size when none is given and caps it at the maximum
+func (s *Service) ListLocations(ctx context.Context, filter LocationFilter) ([]Location, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = defaultPageSize
+	} else if filter.Limit > maxPageSize {
+		filter.Limit = maxPageSize
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+	for i := range filter.Tags {
+		if err := normalizeTag(&filter.Tags[i]); err != nil {
+			return nil, err
+		}
+	}
+	if filter.AsOf.IsZero() {
+		filter.AsOf = time.Now()
+	}
+
+	locations, err := s.repo.ListLocations(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return setStatus(locations, filter.AsOf), nil
+}
+
+// setStatus sets the status of each location as of a point in time
+func setStatus(locations []Location, asOf time.Time) []Location {
+	for i := range locations {
+		locations[i].Status = locations[i].StatusAt(asOf)
+	}
+	return locations
+}
+
+func (s *Service) GetOpeningHours(ctx context.Context, locationId uuid.UUID) (*OpeningHours, error) {
+	hours, err := s.repo.GetOpeningHours(ctx, locationId)
+	if err != nil {
+		return nil, err
+	}
+
+	hours.OpenNow = hours.IsOpenAt(time.Now())
+	return hours, nil
+}
+
+func (s *Service) ReplaceOpeningHours(ctx context.Context, hours *OpeningHours) (*OpeningHours, error) {
+	if err := hours.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.ReplaceOpeningHours(ctx, hours); err != nil {
+		return nil, err
+	}
+
+	hours.OpenNow = hours.IsOpenAt(time.Now())
+	return hours, nil
+}
+
+func (s *Service) GetChildren(ctx context.Context, locationId uuid.UUID) ([]Location, error) {
+	if _, err := s.repo.GetLocationById(ctx, locationId, time.Now()); err != nil {
+		return nil, err
+	}
+
+	locations, err := s.repo.GetChildren(ctx, locationId)
+	if err != nil {
+		return nil, err
+	}
+	return setStatus(locations, time.Now()), nil
+}
+
+func (s *Service) GetAncestors(ctx context.Context, locationId uuid.UUID) ([]Location, error) {
+	if _, err := s.repo.GetLocationById(ctx, locationId, time.Now()); err != nil {
+		return nil, err
+	}
+
+	locations, err := s.repo.GetAncestors(ctx, locationId)
+	if err != nil {
+		return nil, err
+	}
+	return setStatus(locations, time.Now()), nil
 }
 
-func (s *Service) GetLocationById(ctx context.Context, locationId uuid.UUID) (*Location, error) {
-	return s.repo.GetLocationById(ctx, locationId)
+// GetDescendants returns the subtree under the location, a maxDepth of zero (or beyond the hierarchy limit)
+// returns every level
+func (s *Service) GetDescendants(ctx context.Context, locationId uuid.UUID, maxDepth int) ([]Location, error) {
+	if maxDepth <= 0 || maxDepth > maxHierarchyDepth {
+		maxDepth = maxHierarchyDepth
+	}
+	if _, err := s.repo.GetLocationById(ctx, locationId, time.Now()); err != nil {
+		return nil, err
+	}
+
+	locations, err := s.repo.GetDescendants(ctx, locationId, maxDepth)
+	if err != nil {
+		return nil, err
+	}
+	return setStatus(locations, time.Now()), nil
+}
+
+// DeactivateLocation removes the location from service, cascade also deactivates everything beneath it while
+// without it a location that still has active children is left alone
+func (s *Service) DeactivateLocation(ctx context.Context, locationId uuid.UUID, cascade bool) error {
+	return s.repo.DeactivateLocation(ctx, locationId, cascade)
+}
+
+func (s *Service) CreateTag(ctx context.Context, tag *Tag) (*Tag, error) {
+	if err := normalizeTag(&tag.Name); err != nil {
+		return nil, err
+	}
+	if err := s.repo.CreateTag(ctx, tag); err != nil {
+		return nil, err
+	}
+	return tag, nil
+}
+
+func (s *Service) GetTag(ctx context.Context, name string) (*Tag, error) {
+	if err := normalizeTag(&name); err != nil {
+		return nil, err
+	}
+
+	tags, err := s.repo.ListTags(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) == 0 {
+		return nil, ErrTagNotFound
+	}
+	return &tags[0], nil
+}
+
+func (s *Service) ListTags(ctx context.Context) ([]Tag, error) {
+	return s.repo.ListTags(ctx, "")
+}
+
+func (s *Service) UpdateTag(ctx context.Context, tag *Tag) (*Tag, error) {
+	if err := normalizeTag(&tag.Name); err != nil {
+		return nil, err
+	}
+	if err := s.repo.UpdateTag(ctx, tag); err != nil {
+		return nil, err
+	}
+
+	// read back from the leaders, a follower read could miss the update that was just made
+	strongCtx := shared.WithReadConsistency(ctx, shared.ReadConsistency{Mode: shared.ReadConsistencyStrong})
+	return s.GetTag(strongCtx, tag.Name)
+}
+
+func (s *Service) DeleteTag(ctx context.Context, name string) error {
+	if err := normalizeTag(&name); err != nil {
+		return err
+	}
+	return s.repo.DeleteTag(ctx, name)
+}
+
+func (s *Service) AttachTag(ctx context.Context, locationId uuid.UUID, name string) error {
+	if err := normalizeTag(&name); err != nil {
+		return err
+	}
+	return s.repo.AttachTag(ctx, locationId, name)
+}
+
+func (s *Service) DetachTag(ctx context.Context, locationId uuid.UUID, name string) error {
+	if err := normalizeTag(&name); err != nil {
+		return err
+	}
+	return s.repo.DetachTag(ctx, locationId, name)
+}
+
+// normalizeTag lower cases and trims the tag name in place before checking it is a valid slug
+func normalizeTag(name *string) error {
+	*name = strings.ToLower(strings.TrimSpace(*name))
+	if !tagPattern.MatchString(*name) {
+		return &ValidationError{Field: "tag", Message: "must be 1-63 lower case letters, digits or ._:- characters"}
+	}
+	return nil
+}
+
+// GetLocationById returns the location with its status as of the given time, or now when asOf is zero
+func (s *Service) GetLocationById(ctx context.Context, locationId uuid.UUID, asOf time.Time) (*Location, error) {
+	if asOf.IsZero() {
+		asOf = time.Now()
+	}
+
+	location, err := s.repo.GetLocationById(ctx, locationId, asOf)
+	if err != nil {
+		return nil, err
+	}
+
+	location.Status = location.StatusAt(asOf)
+	return location, nil
 }
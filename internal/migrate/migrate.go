@@ -0,0 +1,440 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ssherwood/ysqlapp/internal/config"
+	"github.com/yugabyte/pgx/v5"
+	"github.com/yugabyte/pgx/v5/pgconn"
+	"github.com/yugabyte/pgx/v5/pgxpool"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// lockKey is the pg_advisory_lock key held while migrating, any constant unique to this service will do
+	lockKey = int64(0x7973716c617070)
+	// leaseTTL is how long a lease taken on a server without advisory locks is honored if its holder dies
+	leaseTTL = 10 * time.Minute
+)
+
+var (
+	// ErrSchemaBehind is returned by RequireCurrent when migrations are pending
+	ErrSchemaBehind = errors.New("database schema is behind, run 'migrate up'")
+
+	filenamePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+	// yugabyteClauses are removed from migrations applied to plain Postgres, which does not understand them
+	yugabyteClauses = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)\s+split\s+into\s+\d+\s+tablets`),
+		regexp.MustCompile(`(?i)\s+split\s+at\s+values\s*\((?:[^()]|\([^()]*\))*\)`),
+	}
+	// hashColumnPattern matches a YugabyteDB hash sharded key column such as (location_id hash, tag)
+	hashColumnPattern = regexp.MustCompile(`(?i)(\w+)\s+hash(\s*[,)])`)
+)
+
+// Migration is a versioned schema change read from a pair of <version>_<name>.up.sql and .down.sql files
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+	// Data, when set, rewrites the existing rows before Up is run, see AddData
+	Data DataFunc
+}
+
+// DataFunc changes existing rows where SQL alone cannot (e.g. normalizing them with the application's own code).
+// It runs in the migration's transaction, so a failure leaves the migration pending.
+type DataFunc func(ctx context.Context, tx pgx.Tx) error
+
+// Status is a migration and when it was applied, AppliedAt is nil while the migration is pending
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+type Migrator struct {
+	db         *pgxpool.Pool
+	migrations []Migration
+}
+
+// New loads the migrations from every source, versions must be unique across all of them.
+func New(db *pgxpool.Pool, sources ...fs.FS) (*Migrator, error) {
+	migrations, err := Load(sources...)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// AddData runs fn before the up.sql of the migration with the version is applied, it is not run again once the
+// migration has been applied nor when the migration is reverted
+func (m *Migrator) AddData(version int64, fn DataFunc) error {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			m.migrations[i].Data = fn
+			return nil
+		}
+	}
+	return fmt.Errorf("no migration has the version %d for its data migration", version)
+}
+
+// Load reads the *.up.sql and *.down.sql files found anywhere in the sources, ordered by version.
+func Load(sources ...fs.FS) ([]Migration, error) {
+	byVersion := make(map[int64]*Migration)
+
+	for _, source := range sources {
+		err := fs.WalkDir(source, ".", func(filePath string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+
+			match := filenamePattern.FindStringSubmatch(path.Base(filePath))
+			if match == nil {
+				return nil
+			}
+
+			version, _ := strconv.ParseInt(match[1], 10, 64)
+			contents, err := fs.ReadFile(source, filePath)
+			if err != nil {
+				return err
+			}
+
+			migration, exists := byVersion[version]
+			if !exists {
+				migration = &Migration{Version: version, Name: match[2]}
+				byVersion[version] = migration
+			} else if migration.Name != match[2] {
+				return fmt.Errorf("migration version %d is used by both %s and %s", version, migration.Name, match[2])
+			}
+
+			if match[3] == "up" {
+				migration.Up = string(contents)
+			} else {
+				migration.Down = string(contents)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up.sql", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Up applies up to steps pending migrations in version order, all of them when steps is zero.
+func (m *Migrator) Up(ctx context.Context, steps int) ([]Migration, error) {
+	var applied []Migration
+
+	err := m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		statuses, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		yugabyte, err := isYugabyteDB(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, status := range statuses {
+			if status.AppliedAt != nil {
+				continue
+			}
+			if steps > 0 && len(applied) == steps {
+				break
+			}
+
+			err = m.apply(ctx, conn, status.Migration, status.Data, adapt(status.Up, yugabyte),
+				`INSERT INTO migrations (version, name) VALUES ($1, $2)`, status.Version, status.Name)
+			if err != nil {
+				return err
+			}
+			applied = append(applied, status.Migration)
+		}
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down reverts the most recently applied migrations, one when steps is zero.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	if steps <= 0 {
+		steps = 1
+	}
+
+	var reverted []Migration
+	err := m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		statuses, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		yugabyte, err := isYugabyteDB(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(statuses) - 1; i >= 0 && len(reverted) < steps; i-- {
+			status := statuses[i]
+			if status.AppliedAt == nil {
+				continue
+			}
+			if status.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down.sql", status.Version, status.Name)
+			}
+
+			err = m.apply(ctx, conn, status.Migration, nil, adapt(status.Down, yugabyte),
+				`DELETE FROM migrations WHERE version=$1`, status.Version)
+			if err != nil {
+				return err
+			}
+			reverted = append(reverted, status.Migration)
+		}
+		return nil
+	})
+
+	return reverted, err
+}
+
+// Status reports every known migration and whether it has been applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, err := m.db.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	return m.status(ctx, conn)
+}
+
+// RequireCurrent returns ErrSchemaBehind when any migration has not been applied.
+func (m *Migrator) RequireCurrent(ctx context.Context) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	var pending []string
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending = append(pending, fmt.Sprintf("%d_%s", status.Version, status.Name))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: pending %s", ErrSchemaBehind, strings.Join(pending, ", "))
+	}
+
+	return nil
+}
+
+// status reads the applied versions, before the first migration there is no migrations table and nothing
+// has been applied
+func (m *Migrator) status(ctx context.Context, conn *pgxpool.Conn) ([]Status, error) {
+	var tracked bool
+	if err := conn.QueryRow(ctx, `select to_regclass('migrations') is not null`).Scan(&tracked); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]time.Time)
+	if tracked {
+		if err := readApplied(ctx, conn, applied); err != nil {
+			return nil, err
+		}
+	}
+
+	statuses := make([]Status, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses[i] = Status{Migration: migration}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			statuses[i].AppliedAt = &appliedAt
+		}
+	}
+	return statuses, nil
+}
+
+func readApplied(ctx context.Context, conn *pgxpool.Conn, applied map[int64]time.Time) error {
+	rows, err := conn.Query(ctx, `select version, applied_at from migrations`)
+	if err != nil {
+		return err
+	}
+
+	var version int64
+	var appliedAt time.Time
+	_, err = pgx.ForEachRow(rows, []any{&version, &appliedAt}, func() error {
+		applied[version] = appliedAt
+		return nil
+	})
+	return err
+}
+
+// apply runs the migration's data function, when it has one, and SQL and records it in the migrations table in a
+// single transaction
+func (m *Migrator) apply(ctx context.Context, conn *pgxpool.Conn, migration Migration, data DataFunc, sql string, record string, args ...any) error {
+	start := time.Now()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if data != nil {
+		if err = data(ctx, tx); err != nil {
+			return fmt.Errorf("migration %d_%s failed migrating data: %w", migration.Version, migration.Name, err)
+		}
+	}
+	if _, err = tx.Exec(ctx, sql); err != nil {
+		return fmt.Errorf("migration %d_%s failed: %w", migration.Version, migration.Name, err)
+	}
+	if _, err = tx.Exec(ctx, record, args...); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "Applied migration", slog.Int64("version", migration.Version), slog.String("name", migration.Name),
+		slog.Duration("duration", time.Since(start)))
+	return nil
+}
+
+// withLock runs fn while holding the migration lock so that concurrently starting replicas do not race. It uses
+// a session advisory lock and falls back to a lease row on servers that do not support advisory locks.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := m.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, `select pg_advisory_lock($1)`, lockKey)
+	if err == nil {
+		defer func() {
+			if _, err := conn.Exec(context.Background(), `select pg_advisory_unlock($1)`, lockKey); err != nil {
+				slog.WarnContext(ctx, "Unable to release the migration advisory lock", config.ErrAttr(err))
+			}
+		}()
+		return createTableAndRun(ctx, conn, fn)
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "0A000" {
+		return err
+	}
+
+	slog.DebugContext(ctx, "Advisory locks are not supported, using a lease to lock migrations")
+	holder, err := m.acquireLease(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if _, err := conn.Exec(context.Background(), `delete from migrations_lock where id=1 and locked_by=$1`, holder); err != nil {
+			slog.WarnContext(ctx, "Unable to release the migration lease", config.ErrAttr(err))
+		}
+	}()
+
+	return createTableAndRun(ctx, conn, fn)
+}
+
+// createTableAndRun creates the migrations tracking table if this is the first migration, then runs fn
+func createTableAndRun(ctx context.Context, conn *pgxpool.Conn, fn func(conn *pgxpool.Conn) error) error {
+	_, err := conn.Exec(ctx,
+		`create table if not exists migrations
+             (
+                 version    bigint primary key,
+                 name       text        not null,
+                 applied_at timestamptz not null default current_timestamp
+             )`)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+// acquireLease polls until it takes the single row lease in migrations_lock, or the lease held by another
+// process has expired
+func (m *Migrator) acquireLease(ctx context.Context, conn *pgxpool.Conn) (string, error) {
+	_, err := conn.Exec(ctx,
+		`create table if not exists migrations_lock
+             (
+                 id        int primary key,
+                 locked_by text        not null,
+                 locked_at timestamptz not null
+             )`)
+	if err != nil {
+		return "", err
+	}
+
+	hostname, _ := os.Hostname()
+	holder := fmt.Sprintf("%s:%d:%d", hostname, os.Getpid(), time.Now().UnixNano())
+
+	for {
+		rows, err := conn.Query(ctx,
+			`INSERT INTO migrations_lock (id, locked_by, locked_at)
+                  VALUES (1, $1, current_timestamp)
+             ON CONFLICT (id) DO UPDATE
+                     SET locked_by=excluded.locked_by, locked_at=excluded.locked_at
+                   WHERE migrations_lock.locked_at < current_timestamp - make_interval(secs => $2)
+               RETURNING locked_by`, holder, leaseTTL.Seconds())
+		if err != nil {
+			return "", err
+		}
+		acquired := rows.Next()
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return "", err
+		}
+		if acquired {
+			return holder, nil
+		}
+
+		slog.InfoContext(ctx, "Waiting for another process to finish migrating")
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func isYugabyteDB(ctx context.Context, conn *pgxpool.Conn) (bool, error) {
+	var version string
+	if err := conn.QueryRow(ctx, `select version()`).Scan(&version); err != nil {
+		return false, err
+	}
+	return strings.Contains(version, "-YB-"), nil
+}
+
+// adapt strips the YugabyteDB specific clauses (tablet splitting, hash sharded key columns) from a migration
+// when it is applied to plain Postgres, on YugabyteDB it is returned unchanged
+func adapt(sql string, yugabyte bool) string {
+	if yugabyte {
+		return sql
+	}
+
+	for _, clause := range yugabyteClauses {
+		sql = clause.ReplaceAllString(sql, "")
+	}
+	return hashColumnPattern.ReplaceAllString(sql, "$1$2")
+}
@@ -0,0 +1,158 @@
+// Package module is how a domain (e.g. location) plugs into the service. A domain package implements Module and
+// registers it from its init function, the application then composes the modules enabled by MODULES.
+package module
+
+import (
+	"context"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/ssherwood/ysqlapp/internal/config"
+	"github.com/ssherwood/ysqlapp/internal/health"
+	"github.com/ssherwood/ysqlapp/internal/lifecycle"
+	"github.com/ssherwood/ysqlapp/internal/migrate"
+	"github.com/yugabyte/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/metric"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DependsOnDB is the lifecycle component every job and server using the database depends on
+const DependsOnDB = "db_pool"
+
+// Module is a domain of the service with its own routes, schema, background jobs, health checks and metrics
+type Module interface {
+	// Name identifies the module in MODULES and prefixes its jobs and health checks
+	Name() string
+	// Migrations returns the module's versioned schema, nil when it has none. Versions must be unique across every
+	// module since they share the migrations table.
+	Migrations() fs.FS
+	// Config returns a pointer to a new struct of the module's settings with their defaults, nil when it has none.
+	// It is read from modules.<name> in the config file and from the env names of its fields, and validated by its
+	// Validate() error method when it has one, see config.RegisterModule.
+	Config() any
+	// Init builds the module and registers its routes, jobs, health checks and metrics with the host
+	Init(ctx context.Context, host *Host) error
+}
+
+// DataMigrator is implemented by a module whose migrations also rewrite existing rows in Go, each DataFunc runs
+// before the up.sql of the migration with its version
+type DataMigrator interface {
+	DataMigrations() map[int64]migrate.DataFunc
+}
+
+// Host is what the application provides a module during Init
+type Host struct {
+	Config *config.Config
+	DB     *pgxpool.Pool
+	// Router is the public router, requests through it already carry the read consistency
+	Router *mux.Router
+	// Meter is scoped to the module
+	Meter     metric.Meter
+	Health    *health.Checker
+	Lifecycle *lifecycle.Manager
+
+	module string
+}
+
+func NewHost(m Module, cfg *config.Config, db *pgxpool.Pool, router *mux.Router, meter metric.Meter,
+	checker *health.Checker, manager *lifecycle.Manager) *Host {
+	return &Host{Config: cfg, DB: db, Router: router, Meter: meter, Health: checker, Lifecycle: manager, module: m.Name()}
+}
+
+// ModuleConfig returns the module's loaded settings, the pointer its Config returned the defaults in
+func (h *Host) ModuleConfig() any {
+	return h.Config.Modules.Sections[h.module]
+}
+
+// AddJob runs the job in the background from start to shutdown, it stops before the pool
+func (h *Host) AddJob(name string, run func(ctx context.Context), stopTimeout time.Duration) {
+	h.Lifecycle.Add(lifecycle.Job(h.module+"."+name, run, stopTimeout, DependsOnDB))
+}
+
+// AddCheck adds a readiness check, a critical check failing makes the whole service not ready
+func (h *Host) AddCheck(name string, critical bool, fn health.CheckFunc) {
+	h.Health.Add(h.module+"."+name, critical, fn)
+}
+
+var (
+	mu      sync.Mutex
+	modules = map[string]Module{}
+)
+
+// Register makes the module available to MODULES along with its configuration section, registering a name twice is a programming error and panics
+func Register(m Module) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := modules[m.Name()]; ok {
+		panic(fmt.Sprintf("module: %s registered twice", m.Name()))
+	}
+	modules[m.Name()] = m
+	if m.Config() != nil {
+		config.RegisterModule(m.Name(), m.Config)
+	}
+}
+
+// sortedNames returns the name of every registered module, the caller holds mu
+func sortedNames() []string {
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Enabled returns the named modules in the order given
+func Enabled(names []string) ([]Module, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	enabled := make([]Module, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		m, ok := modules[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown module '%s', registered modules are %s", name, strings.Join(sortedNames(), ", "))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("module '%s' is listed twice", name)
+		}
+		seen[name] = true
+		enabled = append(enabled, m)
+	}
+	return enabled, nil
+}
+
+// Migrations returns the migrations of every module that has them, for migrate.New
+func Migrations(modules []Module) []fs.FS {
+	var sources []fs.FS
+	for _, m := range modules {
+		if migrations := m.Migrations(); migrations != nil {
+			sources = append(sources, migrations)
+		}
+	}
+	return sources
+}
+
+// NewMigrator loads the migrations of the modules along with the data migrations of those that have them
+func NewMigrator(db *pgxpool.Pool, modules []Module) (*migrate.Migrator, error) {
+	migrator, err := migrate.New(db, Migrations(modules)...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range modules {
+		if dataMigrator, ok := m.(DataMigrator); ok {
+			for version, fn := range dataMigrator.DataMigrations() {
+				if err = migrator.AddData(version, fn); err != nil {
+					return nil, fmt.Errorf("module %s: %w", m.Name(), err)
+				}
+			}
+		}
+	}
+	return migrator, nil
+}
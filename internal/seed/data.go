@@ -0,0 +1,70 @@
+package seed
+
+// city anchors generated addresses, coordinates are jittered around its center and postal codes built from its
+// prefix in the country's format
+type city struct {
+	name         string
+	state        string
+	country      string
+	latitude     float64
+	longitude    float64
+	postalPrefix string
+	timeZone     string
+}
+
+var cities = []city{
+	{"New York", "NY", "US", 40.7128, -74.0060, "100", "America/New_York"},
+	{"Brooklyn", "NY", "US", 40.6782, -73.9442, "112", "America/New_York"},
+	{"Boston", "MA", "US", 42.3601, -71.0589, "021", "America/New_York"},
+	{"Philadelphia", "PA", "US", 39.9526, -75.1652, "191", "America/New_York"},
+	{"Atlanta", "GA", "US", 33.7490, -84.3880, "303", "America/New_York"},
+	{"Miami", "FL", "US", 25.7617, -80.1918, "331", "America/New_York"},
+	{"Chicago", "IL", "US", 41.8781, -87.6298, "606", "America/Chicago"},
+	{"Houston", "TX", "US", 29.7604, -95.3698, "770", "America/Chicago"},
+	{"Dallas", "TX", "US", 32.7767, -96.7970, "752", "America/Chicago"},
+	{"San Antonio", "TX", "US", 29.4241, -98.4936, "782", "America/Chicago"},
+	{"Denver", "CO", "US", 39.7392, -104.9903, "802", "America/Denver"},
+	{"Phoenix", "AZ", "US", 33.4484, -112.0740, "850", "America/Phoenix"},
+	{"Los Angeles", "CA", "US", 34.0522, -118.2437, "900", "America/Los_Angeles"},
+	{"San Diego", "CA", "US", 32.7157, -117.1611, "921", "America/Los_Angeles"},
+	{"San Francisco", "CA", "US", 37.7749, -122.4194, "941", "America/Los_Angeles"},
+	{"Seattle", "WA", "US", 47.6062, -122.3321, "981", "America/Los_Angeles"},
+	{"Toronto", "ON", "CA", 43.6532, -79.3832, "M5", "America/Toronto"},
+	{"Montreal", "QC", "CA", 45.5017, -73.5673, "H2", "America/Toronto"},
+	{"Vancouver", "BC", "CA", 49.2827, -123.1207, "V6", "America/Vancouver"},
+	{"London", "EN", "GB", 51.5074, -0.1278, "EC", "Europe/London"},
+	{"Manchester", "EN", "GB", 53.4808, -2.2426, "M", "Europe/London"},
+	{"Berlin", "BE", "DE", 52.5200, 13.4050, "10", "Europe/Berlin"},
+	{"Munich", "BY", "DE", 48.1351, 11.5820, "80", "Europe/Berlin"},
+}
+
+var streetNames = []string{
+	"Main", "Oak", "Pine", "Maple", "Cedar", "Elm", "Washington", "Lake", "Hill", "Park", "1st", "2nd", "3rd",
+	"Church", "Market", "Franklin", "Highland", "Sunset", "River", "Spring", "Lincoln", "Jefferson", "Madison",
+	"Walnut", "Chestnut", "Willow", "Meadow", "Forest", "Ridge", "Harbor",
+}
+
+// streetSuffixes are deliberately long form, the generated addresses go through the same normalization as the API
+var streetSuffixes = []string{"Street", "Avenue", "Boulevard", "Road", "Drive", "Lane", "Court", "Place", "Parkway"}
+
+var directionals = []string{"", "", "", "", "North", "South", "East", "West"}
+
+var formats = []string{"Store", "Store", "Store", "Outlet", "Warehouse", "Office", "Distribution Center", "Kiosk"}
+
+var neighborhoods = []string{
+	"Downtown", "Uptown", "Midtown", "Riverside", "Eastside", "Westside", "Northgate", "Southpoint", "Old Town",
+	"Harbor", "Airport", "University", "Market Square", "Lakeside",
+}
+
+// tags are weighted by repetition, most locations are plain stores
+var tags = []string{"flagship", "24h", "24h", "drive-thru", "drive-thru", "curbside", "curbside", "curbside", "pilot-2026", "outlet", "accessible", "accessible", "accessible"}
+
+var tagDescriptions = map[string]string{
+	"flagship":   "Flagship location",
+	"24h":        "Open 24 hours",
+	"drive-thru": "Has a drive-thru",
+	"curbside":   "Offers curbside pickup",
+	"pilot-2026": "Part of the 2026 pilot program",
+	"outlet":     "Outlet pricing",
+	"accessible": "Step free access",
+}
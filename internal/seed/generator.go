@@ -0,0 +1,217 @@
+// Package seed generates synthetic but realistic locations for development and load testing.
+package seed
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/ssherwood/ysqlapp/internal/location"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	kindAddress uint64 = iota + 1
+	kindLocation
+)
+
+// DefaultReferenceDate is the ReferenceDate when none is given
+var DefaultReferenceDate = time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Options control the volume and shape of the generated data, the same Seed and ReferenceDate always generate the
+// same data.
+type Options struct {
+	Seed      uint64
+	Locations int
+	Addresses int
+	BatchSize int
+	// ReferenceDate is the day the effective windows are scheduled around, DefaultReferenceDate when zero
+	ReferenceDate time.Time
+}
+
+// Record is a generated location along with the metadata that is only written to the database
+type Record struct {
+	location.Location
+	Metadata map[string]any `json:"metadata"`
+}
+
+// Generator derives every address and location from its index, so any row can be generated independently and
+// the data set is never held in memory.
+type Generator struct {
+	opts Options
+	// the start of the reference date's day (UTC)
+	reference time.Time
+
+	// house numbers are a seeded permutation of 1..houseNumbers so every address, and its normalized key, is unique
+	houseNumbers int
+	houseStep    int
+	houseOffset  int
+}
+
+func NewGenerator(opts Options) (*Generator, error) {
+	if opts.Locations < 0 || opts.Addresses < 1 {
+		return nil, fmt.Errorf("seed requires at least one address and a non-negative number of locations")
+	}
+	if opts.BatchSize < 1 {
+		return nil, fmt.Errorf("seed batch size must be positive")
+	}
+
+	houseNumbers := max(9999, opts.Addresses)
+	houseStep := 7919
+	for gcd(houseStep, houseNumbers) != 1 {
+		houseStep += 2
+	}
+
+	if opts.ReferenceDate.IsZero() {
+		opts.ReferenceDate = DefaultReferenceDate
+	}
+
+	return &Generator{
+		opts:         opts,
+		reference:    opts.ReferenceDate.UTC().Truncate(24 * time.Hour),
+		houseNumbers: houseNumbers,
+		houseStep:    houseStep,
+		houseOffset:  int(splitmix(opts.Seed) % uint64(houseNumbers)),
+	}, nil
+}
+
+func (g *Generator) Options() Options {
+	return g.opts
+}
+
+// Address generates the i'th address, its street, city and postal code are normalized as the API would
+func (g *Generator) Address(i int) location.Location {
+	rng := g.rand(kindAddress, i)
+	c := cities[rng.IntN(len(cities))]
+
+	house := 1 + (i*g.houseStep+g.houseOffset)%g.houseNumbers
+	street := fmt.Sprintf("%d %s %s %s", house, pick(rng, directionals), pick(rng, streetNames), pick(rng, streetSuffixes))
+	if rng.IntN(5) == 0 {
+		street += fmt.Sprintf(" Suite %d", 100+rng.IntN(900))
+	}
+
+	address := location.Location{
+		AddressId:  newUUID(rng),
+		Street:     street,
+		City:       c.name,
+		State:      c.state,
+		PostalCode: postalCode(rng, c),
+		Country:    c.country,
+		// ~0.05 degrees is a few kilometers, enough to spread addresses across the metro area
+		Latitude:  round(c.latitude+rng.NormFloat64()*0.05, 6),
+		Longitude: round(c.longitude+rng.NormFloat64()*0.05, 6),
+		TimeZone:  c.timeZone,
+	}
+	// every city has a country, so the address always normalizes
+	_ = location.NormalizeAddress(&address)
+	return address
+}
+
+// Location generates the i'th location, addresses are shared round-robin when there are fewer than locations
+func (g *Generator) Location(i int) Record {
+	record := Record{Location: g.Address(i % g.opts.Addresses)}
+
+	rng := g.rand(kindLocation, i)
+	format := pick(rng, formats)
+	neighborhood := pick(rng, neighborhoods)
+
+	record.ID = newUUID(rng)
+	record.Name = fmt.Sprintf("%s %s %s #%d", record.City, neighborhood, format, 1000+i)
+	record.Description = fmt.Sprintf("%s %s serving the %s area", neighborhood, strings.ToLower(format), record.City)
+	record.Active = true
+	record.Status = location.StatusActive
+
+	// a few locations are scheduled to open in the weeks after the reference date and a few closed in the months
+	// before it
+	switch n := rng.IntN(100); {
+	case n < 3:
+		from := g.reference.AddDate(0, 0, 1+rng.IntN(60))
+		record.EffectiveFrom = &from
+		record.Status = location.StatusScheduled
+	case n < 5:
+		to := g.reference.AddDate(0, 0, -1-rng.IntN(180))
+		record.EffectiveTo = &to
+		record.Status = location.StatusExpired
+	}
+
+	for range rng.IntN(4) {
+		tag := pick(rng, tags)
+		if !slices.Contains(record.Tags, tag) {
+			record.Tags = append(record.Tags, tag)
+		}
+	}
+
+	record.Metadata = map[string]any{
+		"format":      strings.ToLower(format),
+		"square_feet": 500 + rng.IntN(60)*500,
+		"opened_year": 1985 + rng.IntN(g.reference.Year()-1985+1),
+		"region":      record.Country + "-" + record.State,
+	}
+	return record
+}
+
+// Tags returns every tag the generator may attach with its description
+func (g *Generator) Tags() map[string]string {
+	return tagDescriptions
+}
+
+// rand returns a generator seeded by the row kind and index so each row is independent of how many precede it
+func (g *Generator) rand(kind uint64, i int) *rand.Rand {
+	return rand.New(rand.NewPCG(splitmix(g.opts.Seed^kind), splitmix(kind<<56|uint64(i))))
+}
+
+// splitmix scrambles the seed words, neighbouring indexes would otherwise produce correlated first draws
+func splitmix(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// uuid draws a version 4 UUID from the seeded generator rather than crypto/rand so it is reproducible
+func newUUID(rng *rand.Rand) uuid.UUID {
+	var id uuid.UUID
+	for i := 0; i < len(id); i += 8 {
+		v := rng.Uint64()
+		for j := 0; j < 8; j++ {
+			id[i+j] = byte(v >> (8 * j))
+		}
+	}
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return id
+}
+
+// postalCode builds a code in the country's format from the city's prefix
+func postalCode(rng *rand.Rand, c city) string {
+	letter := func() byte { return byte('A' + rng.IntN(26)) }
+
+	switch c.country {
+	case "US":
+		return fmt.Sprintf("%s%02d", c.postalPrefix, rng.IntN(100))
+	case "CA":
+		return fmt.Sprintf("%s%c %d%c%d", c.postalPrefix, letter(), rng.IntN(10), letter(), rng.IntN(10))
+	case "GB":
+		return fmt.Sprintf("%s%d %d%c%c", c.postalPrefix, 1+rng.IntN(9), rng.IntN(10), letter(), letter())
+	default:
+		return fmt.Sprintf("%s%03d", c.postalPrefix, rng.IntN(1000))
+	}
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+func pick(rng *rand.Rand, values []string) string {
+	return values[rng.IntN(len(values))]
+}
+
+func round(value float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(value*scale) / scale
+}
@@ -0,0 +1,94 @@
+package seed
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ssherwood/ysqlapp/internal/location"
+	"github.com/yugabyte/pgx/v5"
+	"github.com/yugabyte/pgx/v5/pgxpool"
+	"io"
+	"log/slog"
+	"time"
+)
+
+var (
+	addressColumns     = []string{"id", "street", "city", "state_cd", "postal_cd", "country_cd", "longitude", "latitude", "normalized_key"}
+	locationColumns    = []string{"id", "name", "description", "address_id", "metadata", "time_zone", "effective_from", "effective_to"}
+	locationTagColumns = []string{"location_id", "tag"}
+)
+
+// Load writes the generated addresses, locations and tags to the database with batched COPY. Each batch commits on
+// its own so a large seed does not become a single huge transaction, loading into an already seeded database
+// fails on the duplicate ids or address keys.
+func Load(ctx context.Context, db *pgxpool.Pool, g *Generator) error {
+	start := time.Now()
+
+	for name, description := range g.Tags() {
+		if _, err := db.Exec(ctx, `insert into tag(name, description) values ($1, $2) on conflict (name) do nothing`, name, description); err != nil {
+			return fmt.Errorf("seed tag %s: %w", name, err)
+		}
+	}
+
+	if err := copyBatches(ctx, db, "address", addressColumns, g.opts.Addresses, g.opts.BatchSize, func(i int) [][]any {
+		a := g.Address(i)
+		return [][]any{{a.AddressId, a.Street, a.City, a.State, a.PostalCode, a.Country, a.Longitude, a.Latitude, location.AddressKey(&a)}}
+	}); err != nil {
+		return err
+	}
+
+	if err := copyBatches(ctx, db, "location", locationColumns, g.opts.Locations, g.opts.BatchSize, func(i int) [][]any {
+		l := g.Location(i)
+		return [][]any{{l.ID, l.Name, l.Description, l.AddressId, l.Metadata, l.TimeZone, l.EffectiveFrom, l.EffectiveTo}}
+	}); err != nil {
+		return err
+	}
+
+	if err := copyBatches(ctx, db, "location_tag", locationTagColumns, g.opts.Locations, g.opts.BatchSize, func(i int) [][]any {
+		l := g.Location(i)
+		rows := make([][]any, 0, len(l.Tags))
+		for _, tag := range l.Tags {
+			rows = append(rows, []any{l.ID, tag})
+		}
+		return rows
+	}); err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "Seeded database",
+		slog.Int("addresses", g.opts.Addresses),
+		slog.Int("locations", g.opts.Locations),
+		slog.Uint64("seed", g.opts.Seed),
+		slog.Duration("elapsed", time.Since(start)))
+	return nil
+}
+
+// copyBatches COPYs the rows generated for indexes [0, count) into the table, batchSize indexes at a time
+func copyBatches(ctx context.Context, db *pgxpool.Pool, table string, columns []string, count, batchSize int, rows func(i int) [][]any) error {
+	for from := 0; from < count; from += batchSize {
+		to := min(from+batchSize, count)
+
+		batch := make([][]any, 0, to-from)
+		for i := from; i < to; i++ {
+			batch = append(batch, rows(i)...)
+		}
+
+		copied, err := db.CopyFrom(ctx, pgx.Identifier{table}, columns, pgx.CopyFromRows(batch))
+		if err != nil {
+			return fmt.Errorf("seed %s rows %d-%d: %w", table, from, to-1, err)
+		}
+		slog.DebugContext(ctx, "Copied seed batch", slog.String("table", table), slog.Int64("rows", copied), slog.Int("progress", to), slog.Int("total", count))
+	}
+	return nil
+}
+
+// WriteNDJSON writes each generated location, with its address, tags and metadata, as one JSON object per line
+func WriteNDJSON(w io.Writer, g *Generator) error {
+	encoder := json.NewEncoder(w)
+	for i := 0; i < g.opts.Locations; i++ {
+		if err := encoder.Encode(g.Location(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
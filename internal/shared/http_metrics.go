@@ -0,0 +1,127 @@
+package shared
+
+import (
+	"errors"
+	"github.com/felixge/httpsnoop"
+	"github.com/gorilla/mux"
+	"github.com/ssherwood/ysqlapp/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// HTTPStatusClassKey represents the class of the HTTP response status code, e.g. 2xx or 5xx.
+const HTTPStatusClassKey = attribute.Key("http.response.status_class")
+
+// the request methods recorded as they are, any other is recorded as _OTHER so a client cannot add attribute values
+var httpMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true, http.MethodPatch: true,
+	http.MethodDelete: true, http.MethodConnect: true, http.MethodOptions: true, http.MethodTrace: true,
+}
+
+// httpMetricViews sets the histogram buckets of the HTTP server metrics from OTEL_HTTP_DURATION_BUCKETS and
+// OTEL_HTTP_SIZE_BUCKETS
+func httpMetricViews(cfg config.OTelConfig) []sdkmetric.View {
+	buckets := func(name string, boundaries []float64) sdkmetric.View {
+		return sdkmetric.NewView(
+			sdkmetric.Instrument{Name: name},
+			sdkmetric.Stream{Aggregation: sdkmetric.AggregationExplicitBucketHistogram{Boundaries: boundaries}},
+		)
+	}
+
+	return []sdkmetric.View{
+		buckets(semconv.HTTPServerRequestDurationName, cfg.HTTPDurationBuckets),
+		buckets(semconv.HTTPServerRequestBodySizeName, cfg.HTTPSizeBuckets),
+		buckets(semconv.HTTPServerResponseBodySizeName, cfg.HTTPSizeBuckets),
+	}
+}
+
+// NewHTTPMetricsMiddleware records the rate, errors and duration of the requests along with the requests in flight
+// and the request and response body sizes, by method, route template (e.g. /locations/{id}, never the raw path)
+// and status class. Requests that match no route never reach router middleware, so they are not recorded.
+func NewHTTPMetricsMiddleware() (mux.MiddlewareFunc, error) {
+	meter := otel.Meter("github.com/ssherwood/ysqlapp/internal/shared/http",
+		metric.WithInstrumentationAttributes(
+			semconv.ServiceName(config.ServiceName),
+		),
+	)
+
+	duration, err1 := meter.Float64Histogram(semconv.HTTPServerRequestDurationName,
+		metric.WithDescription(semconv.HTTPServerRequestDurationDescription),
+		metric.WithUnit(semconv.HTTPServerRequestDurationUnit))
+	active, err2 := meter.Int64UpDownCounter(semconv.HTTPServerActiveRequestsName,
+		metric.WithDescription(semconv.HTTPServerActiveRequestsDescription),
+		metric.WithUnit(semconv.HTTPServerActiveRequestsUnit))
+	requestSize, err3 := meter.Int64Histogram(semconv.HTTPServerRequestBodySizeName,
+		metric.WithDescription(semconv.HTTPServerRequestBodySizeDescription),
+		metric.WithUnit(semconv.HTTPServerRequestBodySizeUnit))
+	responseSize, err4 := meter.Int64Histogram(semconv.HTTPServerResponseBodySizeName,
+		metric.WithDescription(semconv.HTTPServerResponseBodySizeDescription),
+		metric.WithUnit(semconv.HTTPServerResponseBodySizeUnit))
+	if err := errors.Join(err1, err2, err3, err4); err != nil {
+		return nil, err
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			method := r.Method
+			if !httpMethods[method] {
+				method = "_OTHER"
+			}
+			var route string
+			if current := mux.CurrentRoute(r); current != nil {
+				route, _ = current.GetPathTemplate()
+			}
+			requestAttrs := metric.WithAttributes(semconv.HTTPRequestMethodKey.String(method), semconv.HTTPRoute(route))
+
+			// without a Content-Length the body size is what the handler read of it
+			var body *countingReader
+			if r.ContentLength < 0 && r.Body != nil && r.Body != http.NoBody {
+				body = &countingReader{ReadCloser: r.Body}
+				r.Body = body
+			}
+
+			active.Add(ctx, 1, requestAttrs)
+			start := time.Now()
+			captured := httpsnoop.CaptureMetricsFn(w, func(w http.ResponseWriter) {
+				next.ServeHTTP(w, r)
+			})
+			elapsed := time.Since(start)
+			active.Add(ctx, -1, requestAttrs)
+
+			bodySize := r.ContentLength
+			if body != nil {
+				bodySize = body.n.Load()
+			}
+
+			attrs := metric.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(method),
+				semconv.HTTPRoute(route),
+				HTTPStatusClassKey.String(strconv.Itoa(captured.Code/100)+"xx"),
+			)
+			duration.Record(ctx, elapsed.Seconds(), attrs)
+			requestSize.Record(ctx, max(bodySize, 0), attrs)
+			responseSize.Record(ctx, captured.Written, attrs)
+		})
+	}, nil
+}
+
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,73 @@
+package shared
+
+import (
+	"context"
+	"github.com/ssherwood/ysqlapp/internal/config"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"log/slog"
+)
+
+// NewConsoleHandler returns the handler of the console log, text or JSON, at config.LogLevel. Every record is
+// correlated with the trace and request of its context, source locations are included while the level is debug.
+func NewConsoleHandler(w io.Writer, format string) slog.Handler {
+	newHandler := func(addSource bool) slog.Handler {
+		options := &slog.HandlerOptions{AddSource: addSource, Level: config.LogLevel}
+		if format == config.LogFormatJSON {
+			return slog.NewJSONHandler(w, options)
+		}
+		return slog.NewTextHandler(w, options)
+	}
+
+	return &ContextHandler{handler: newHandler(false), debugHandler: newHandler(true)}
+}
+
+// ContextHandler adds the trace_id and span_id of the context's span and the request_id, tenant and route of its
+// HTTP request to every record (inside the groups opened by WithGroup, like any record attribute). Records logged
+// without a context (slog.Info rather than slog.InfoContext) cannot be correlated. The level can change at runtime,
+// so the handler adding the source location is picked for each record while the level is debug, the source is only
+// looked up then.
+type ContextHandler struct {
+	handler      slog.Handler
+	debugHandler slog.Handler
+}
+
+func (h *ContextHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *ContextHandler) Handle(ctx context.Context, rec slog.Record) error {
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		rec.AddAttrs(
+			slog.String("trace_id", spanContext.TraceID().String()),
+			slog.String("span_id", spanContext.SpanID().String()))
+	}
+	if info, ok := RequestInfoFromContext(ctx); ok {
+		rec.AddAttrs(slog.String("request_id", info.ID))
+		if info.Tenant != "" {
+			rec.AddAttrs(slog.String("tenant", info.Tenant))
+		}
+		if info.Route != "" {
+			rec.AddAttrs(slog.String("route", info.Route))
+		}
+	}
+
+	if config.LogLevel.Level() <= slog.LevelDebug {
+		return h.debugHandler.Handle(ctx, rec)
+	}
+	return h.handler.Handle(ctx, rec)
+}
+
+func (h *ContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	return &ContextHandler{handler: h.handler.WithAttrs(attrs), debugHandler: h.debugHandler.WithAttrs(attrs)}
+}
+
+func (h *ContextHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &ContextHandler{handler: h.handler.WithGroup(name), debugHandler: h.debugHandler.WithGroup(name)}
+}
@@ -0,0 +1,33 @@
+package shared
+
+import (
+	"github.com/ssherwood/ysqlapp/internal/config"
+	"go.opentelemetry.io/otel"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+type telemetryError struct {
+	err error
+	at  time.Time
+}
+
+var lastTelemetryError atomic.Pointer[telemetryError]
+
+// InitTelemetryErrorHandler logs the errors the OTEL SDK reports (failed exports, dropped data) and remembers the
+// last one so readiness can report the exporter state
+func InitTelemetryErrorHandler() {
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		lastTelemetryError.Store(&telemetryError{err: err, at: time.Now()})
+		slog.Warn("OTEL error", config.ErrAttr(err))
+	}))
+}
+
+// LastTelemetryError returns when the OTEL SDK last reported an error and the error, nil if there was none
+func LastTelemetryError() (time.Time, error) {
+	if last := lastTelemetryError.Load(); last != nil {
+		return last.at, last.err
+	}
+	return time.Time{}, nil
+}
@@ -0,0 +1,48 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"github.com/ssherwood/ysqlapp/internal/config"
+	"go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/trace"
+	"io"
+	"path/filepath"
+)
+
+// openExportFile opens the rotating JSON lines file of the signal (traces, metrics or logs) in
+// OTEL_EXPORTER_FILE_PATH
+func openExportFile(cfg config.OTelConfig, signal string) (*RotatingFile, error) {
+	path := filepath.Join(cfg.FilePath, signal+".jsonl")
+	return OpenRotatingFile(path, int64(cfg.FileMaxSize)<<20, cfg.FileMaxBackups)
+}
+
+// the stdout exporters leave their writer open, these close the file once the exporter has flushed to it
+
+type fileSpanExporter struct {
+	trace.SpanExporter
+	file io.Closer
+}
+
+func (e fileSpanExporter) Shutdown(ctx context.Context) error {
+	return errors.Join(e.SpanExporter.Shutdown(ctx), e.file.Close())
+}
+
+type fileMetricExporter struct {
+	metric.Exporter
+	file io.Closer
+}
+
+func (e fileMetricExporter) Shutdown(ctx context.Context) error {
+	return errors.Join(e.Exporter.Shutdown(ctx), e.file.Close())
+}
+
+type fileLogExporter struct {
+	log.Exporter
+	file io.Closer
+}
+
+func (e fileLogExporter) Shutdown(ctx context.Context) error {
+	return errors.Join(e.Exporter.Shutdown(ctx), e.file.Close())
+}
@@ -2,28 +2,27 @@ package shared
 
 import (
 	"context"
+	"fmt"
 	"github.com/ssherwood/ysqlapp/internal/config"
-	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutlog"
+	otellog "go.opentelemetry.io/otel/log"
 	"go.opentelemetry.io/otel/log/global"
-	"go.opentelemetry.io/otel/sdk/instrumentation"
 	sdklog "go.opentelemetry.io/otel/sdk/log"
-	"go.opentelemetry.io/otel/sdk/resource"
-	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
-	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc/credentials"
 	"log/slog"
-	"os"
+	"math"
 	"time"
 )
 
-func grpcLogOptions() []otlploggrpc.Option {
+func grpcLogOptions(cfg config.OTelConfig) []otlploggrpc.Option {
 	options := []otlploggrpc.Option{
-		otlploggrpc.WithEndpoint(config.OTELCollectorURL),
-		otlploggrpc.WithCompressor(config.OTELCompressor),
+		otlploggrpc.WithEndpoint(cfg.Endpoint.Host),
+		otlploggrpc.WithCompressor(cfg.Compressor),
 	}
 
-	if config.OTELExporterInsecure {
+	if cfg.ExporterInsecure() {
 		options = append(options, otlploggrpc.WithInsecure())
 	} else {
 		options = append(options, otlploggrpc.WithTLSCredentials(
@@ -34,36 +33,58 @@ func grpcLogOptions() []otlploggrpc.Option {
 	return options
 }
 
-func InitializeLoggingProvider(ctx context.Context) (*sdklog.LoggerProvider, error) {
-	//stdoutExporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
-	//if err != nil {
-	//	slog.Warn("Failed to initialize OTEL log stdoutExporter", config.ErrAttr(err))
-	//}
+func httpLogOptions(cfg config.OTelConfig) []otlploghttp.Option {
+	options := []otlploghttp.Option{
+		otlploghttp.WithEndpoint(cfg.Endpoint.Host),
+	}
 
-	grpcExporter, err := otlploggrpc.New(ctx, grpcLogOptions()...)
+	if cfg.Compressor == "gzip" {
+		options = append(options, otlploghttp.WithCompression(otlploghttp.GzipCompression))
+	}
+	if cfg.ExporterInsecure() {
+		options = append(options, otlploghttp.WithInsecure())
+	}
+
+	return options
+}
+
+// newLogExporter creates the exporter selected by OTEL_LOGS_EXPORTER
+func newLogExporter(ctx context.Context, cfg config.OTelConfig) (sdklog.Exporter, error) {
+	switch cfg.LogsExporter {
+	case config.ExporterOTLP:
+		if cfg.Protocol == config.ProtocolHTTPProtobuf {
+			return otlploghttp.New(ctx, httpLogOptions(cfg)...)
+		}
+		return otlploggrpc.New(ctx, grpcLogOptions(cfg)...)
+	case config.ExporterConsole:
+		return stdoutlog.New()
+	case config.ExporterFile:
+		file, err := openExportFile(cfg, "logs")
+		if err != nil {
+			return nil, err
+		}
+		exporter, err := stdoutlog.New(stdoutlog.WithWriter(file))
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		return fileLogExporter{Exporter: exporter, file: file}, nil
+	default:
+		return nil, fmt.Errorf("no log exporter for '%s'", cfg.LogsExporter)
+	}
+}
+
+func InitializeLoggingProvider(ctx context.Context, cfg config.OTelConfig) (*sdklog.LoggerProvider, error) {
+	exporter, err := newLogExporter(ctx, cfg)
 	if err != nil {
-		slog.Error("Unable to initialize OTEL log grpcExporter", config.ErrAttr(err))
+		slog.ErrorContext(ctx, "Unable to initialize OTEL log exporter", config.ErrAttr(err))
 		return nil, err
 	}
 
-	//stdoutExporter, err := stdoutlogs.NewExporter()
-	//if err != nil {
-	//	slog.Error("Unable to initialize OTEL log grpcExporter", config.ErrAttr(err))
-	//	return nil, err
-	//}
-
 	provider := sdklog.NewLoggerProvider(
-		sdklog.WithProcessor(sdklog.NewBatchProcessor(grpcExporter)),
-		//log.WithProcessor(log.NewSimpleProcessor(stdoutExporter)),
+		sdklog.WithProcessor(sdklog.NewBatchProcessor(exporter)),
 		sdklog.WithResource(
-			resource.NewWithAttributes(
-				semconv.SchemaURL,
-				semconv.TelemetrySDKLanguageGo,
-				semconv.ServiceName(config.ServiceName),
-				semconv.ServiceVersion(config.ServiceVersion),
-				semconv.HostNameKey.String(config.Hostname),
-				semconv.ProcessPIDKey.Int64(int64(os.Getpid())),
-			),
+			newResource(cfg),
 		),
 	)
 
@@ -72,131 +93,153 @@ func InitializeLoggingProvider(ctx context.Context) (*sdklog.LoggerProvider, err
 	return provider, nil
 }
 
+// OTLPLogHandler is a slog.Handler writing every record to the console handler and emitting it to the OTEL
+// LoggerProvider. Trace and span IDs are taken from the context by the SDK, so log with the *Context variants
+// (e.g. slog.InfoContext) to correlate records with their trace.
 type OTLPLogHandler struct {
-	consoleHandler slog.Handler
-	exporter       *otlploggrpc.Exporter
+	console slog.Handler
+	logger  otellog.Logger
+	level   slog.Leveler
+	// the attributes and groups added by WithAttrs and WithGroup, in the order they were added
+	scopes []logScope
+}
+
+// logScope is either a group opened by WithGroup or the attributes added by one WithAttrs
+type logScope struct {
+	group string
+	attrs []slog.Attr
 }
 
-func NewOTLPLogHandler(consoleHandler slog.Handler, exporter *otlploggrpc.Exporter) *OTLPLogHandler {
-	return &OTLPLogHandler{consoleHandler: consoleHandler, exporter: exporter}
+func NewOTLPLogHandler(console slog.Handler, provider otellog.LoggerProvider, level slog.Leveler) *OTLPLogHandler {
+	logger := provider.Logger(config.ServiceName, otellog.WithInstrumentationVersion(config.ServiceVersion))
+	return &OTLPLogHandler{console: console, logger: logger, level: level}
+}
+
+func (h *OTLPLogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
 }
 
 func (h *OTLPLogHandler) Handle(ctx context.Context, rec slog.Record) error {
-	// Log to console
-	if err := h.consoleHandler.Handle(ctx, rec); err != nil {
-		return err
+	var consoleErr error
+	if h.console.Enabled(ctx, rec.Level) {
+		consoleErr = h.console.Handle(ctx, rec)
+	}
+
+	var record otellog.Record
+	record.SetTimestamp(rec.Time)
+	record.SetSeverity(otelSeverity(rec.Level))
+	record.SetSeverityText(rec.Level.String())
+	record.SetBody(otellog.StringValue(rec.Message))
+
+	attrs := make([]slog.Attr, 0, rec.NumAttrs())
+	rec.Attrs(func(attr slog.Attr) bool {
+		attrs = append(attrs, attr)
+		return true
+	})
+	record.AddAttributes(h.attributes(attrs)...)
+
+	h.logger.Emit(ctx, record)
+	return consoleErr
+}
+
+// attributes nests the record's attributes in the open groups, innermost first, along with the attributes added
+// at each level. A group left without attributes is omitted as slog requires.
+func (h *OTLPLogHandler) attributes(attrs []slog.Attr) []otellog.KeyValue {
+	current := otelAttributes(attrs)
+	for i := len(h.scopes) - 1; i >= 0; i-- {
+		scope := h.scopes[i]
+		if scope.group == "" {
+			current = append(otelAttributes(scope.attrs), current...)
+		} else if len(current) > 0 {
+			current = []otellog.KeyValue{otellog.Map(scope.group, current...)}
+		}
 	}
+	return current
+}
 
-	//spanContext := trace.SpanFromContext(ctx).SpanContext()
-	//var traceID *trace.TraceID = nil
-	//var spanID *trace.SpanID = nil
-	//var traceFlags *trace.TraceFlags = nil
-	//if spanContext.IsValid() {
-	//	tid := spanContext.TraceID()
-	//	sid := spanContext.SpanID()
-	//	tf := spanContext.TraceFlags()
-	//	traceID = &tid
-	//	spanID = &sid
-	//	traceFlags = &tf
-	//}
-	//levelString := rec.Level.String()
-	//severity := SeverityNumber(int(rec.Level.Level()) + 9)
-	//
-	//var attributes []attribute.KeyValue
-
-	//for _, attr := range o.attrs {
-	//	attributes = append(attributes, otelAttribute(attr)...)
-	//}
-	//
-	//rec.Attrs(func(attr slog.Attr) bool {
-	//	attributes = append(attributes, otelAttribute(withGroupPrefix(o.groupPrefix, attr))...)
-	//	return true
-	//})
-	//
-	//lrc := LogRecordConfig{
-	//	Timestamp:            &rec.Time,
-	//	ObservedTimestamp:    rec.Time,
-	//	TraceId:              traceID,
-	//	SpanId:               spanID,
-	//	TraceFlags:           traceFlags,
-	//	SeverityText:         &levelString,
-	//	SeverityNumber:       &severity,
-	//	Body:                 &rec.Message,
-	//	Resource:             nil,
-	//	InstrumentationScope: &instrumentationScope,
-	//	Attributes:           &attributes,
-	//}
-	//
-	//r := NewLogRecord(lrc)
-	//o.logger.Emit(r)
-
-	return nil
-}
-
-type LogRecordConfig struct {
-	Timestamp            *time.Time
-	ObservedTimestamp    time.Time
-	TraceId              *trace.TraceID
-	SpanId               *trace.SpanID
-	TraceFlags           *trace.TraceFlags
-	SeverityText         *string
-	SeverityNumber       *SeverityNumber
-	Body                 *string
-	Resource             *resource.Resource
-	InstrumentationScope *instrumentation.Scope
-	Attributes           *[]attribute.KeyValue
-}
-
-func NewLogRecord(config LogRecordConfig) LogRecord {
-	return LogRecord{
-		timestamp:            config.Timestamp,
-		observedTimestamp:    config.ObservedTimestamp,
-		traceId:              config.TraceId,
-		spanId:               config.SpanId,
-		traceFlags:           config.TraceFlags,
-		severityText:         config.SeverityText,
-		severityNumber:       config.SeverityNumber,
-		body:                 config.Body,
-		resource:             config.Resource,
-		instrumentationScope: config.InstrumentationScope,
-		attributes:           config.Attributes,
+func (h *OTLPLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
 	}
+	return h.with(logScope{attrs: attrs}, h.console.WithAttrs(attrs))
 }
 
-type LogRecord struct {
-	timestamp            *time.Time
-	observedTimestamp    time.Time
-	traceId              *trace.TraceID
-	spanId               *trace.SpanID
-	traceFlags           *trace.TraceFlags
-	severityText         *string
-	severityNumber       *SeverityNumber
-	body                 *string
-	resource             *resource.Resource
-	instrumentationScope *instrumentation.Scope
-	attributes           *[]attribute.KeyValue
-}
-
-// SeverityNumber Possible values for LogRecord.SeverityNumber.
-type SeverityNumber int32
-
-//func (h *OTLPSlogHandler) Handle(ctx context.Context, rec slog.Record) error {
-//	// Log to console
-//	if err := h.consoleHandler.Handle(ctx, rec); err != nil {
-//		return err
-//	}
-//
-//	// Create a span for logging
-//	_, span := h.tracer.Start(ctx, "log")
-//	defer span.End()
-//
-//	span.SetAttributes(attribute.String("log.message", rec.Message))
-//	span.SetAttributes(attribute.String("log.level", rec.Level.String()))
-//	// Iterate over attributes and set them
-//	rec.Attrs(func(attr slog.Attr) bool {
-//		span.SetAttributes(attribute.String(attr.Key, attr.Value.String()))
-//		return true
-//	})
-//
-//	return nil
-//}
+func (h *OTLPLogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.with(logScope{group: name}, h.console.WithGroup(name))
+}
+
+func (h *OTLPLogHandler) with(scope logScope, console slog.Handler) *OTLPLogHandler {
+	scopes := make([]logScope, len(h.scopes), len(h.scopes)+1)
+	copy(scopes, h.scopes)
+	return &OTLPLogHandler{console: console, logger: h.logger, level: h.level, scopes: append(scopes, scope)}
+}
+
+// otelSeverity maps the slog levels onto the OTEL severity numbers, DEBUG, INFO, WARN and ERROR are 4 apart in
+// both so the levels in between (e.g. INFO+2) keep their place
+func otelSeverity(level slog.Level) otellog.Severity {
+	severity := int(level) + int(otellog.SeverityInfo)
+	return otellog.Severity(min(max(severity, int(otellog.SeverityTrace1)), int(otellog.SeverityFatal4)))
+}
+
+func otelAttributes(attrs []slog.Attr) []otellog.KeyValue {
+	keyValues := make([]otellog.KeyValue, 0, len(attrs))
+	for _, attr := range attrs {
+		attr.Value = attr.Value.Resolve()
+		if attr.Equal(slog.Attr{}) {
+			continue
+		}
+
+		if attr.Value.Kind() == slog.KindGroup {
+			group := otelAttributes(attr.Value.Group())
+			switch {
+			case len(group) == 0:
+			case attr.Key == "":
+				// a group without a key is inlined
+				keyValues = append(keyValues, group...)
+			default:
+				keyValues = append(keyValues, otellog.Map(attr.Key, group...))
+			}
+			continue
+		}
+
+		keyValues = append(keyValues, otellog.KeyValue{Key: attr.Key, Value: otelValue(attr.Value)})
+	}
+	return keyValues
+}
+
+func otelValue(value slog.Value) otellog.Value {
+	switch value.Kind() {
+	case slog.KindString:
+		return otellog.StringValue(value.String())
+	case slog.KindInt64:
+		return otellog.Int64Value(value.Int64())
+	case slog.KindUint64:
+		if v := value.Uint64(); v <= math.MaxInt64 {
+			return otellog.Int64Value(int64(v))
+		}
+		return otellog.StringValue(value.String())
+	case slog.KindFloat64:
+		return otellog.Float64Value(value.Float64())
+	case slog.KindBool:
+		return otellog.BoolValue(value.Bool())
+	case slog.KindDuration:
+		// nanoseconds, as slog.JSONHandler writes them
+		return otellog.Int64Value(int64(value.Duration()))
+	case slog.KindTime:
+		return otellog.StringValue(value.Time().Format(time.RFC3339Nano))
+	}
+
+	switch v := value.Any().(type) {
+	case error:
+		return otellog.StringValue(v.Error())
+	case []byte:
+		return otellog.BytesValue(v)
+	case fmt.Stringer:
+		return otellog.StringValue(v.String())
+	default:
+		return otellog.StringValue(fmt.Sprintf("%+v", v))
+	}
+}
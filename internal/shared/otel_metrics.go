@@ -2,24 +2,24 @@ package shared
 
 import (
 	"context"
+	"fmt"
 	"github.com/ssherwood/ysqlapp/internal/config"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
 	"go.opentelemetry.io/otel/sdk/metric"
-	"go.opentelemetry.io/otel/sdk/resource"
-	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
 	"google.golang.org/grpc/credentials"
 	"log/slog"
-	"os"
 )
 
-func grpcMetricOptions() []otlpmetricgrpc.Option {
+func grpcMetricOptions(cfg config.OTelConfig) []otlpmetricgrpc.Option {
 	options := []otlpmetricgrpc.Option{
-		otlpmetricgrpc.WithEndpoint(config.OTELCollectorURL),
-		otlpmetricgrpc.WithCompressor(config.OTELCompressor),
+		otlpmetricgrpc.WithEndpoint(cfg.Endpoint.Host),
+		otlpmetricgrpc.WithCompressor(cfg.Compressor),
 	}
 
-	if config.OTELExporterInsecure {
+	if cfg.ExporterInsecure() {
 		options = append(options, otlpmetricgrpc.WithInsecure())
 	} else {
 		options = append(options, otlpmetricgrpc.WithTLSCredentials(
@@ -30,31 +30,74 @@ func grpcMetricOptions() []otlpmetricgrpc.Option {
 	return options
 }
 
-// InitializeMetricProvider
-// https://opentelemetry.io/docs/languages/go/instrumentation/#metrics
-func InitializeMetricProvider(ctx context.Context) (*metric.MeterProvider, error) {
+func httpMetricOptions(cfg config.OTelConfig) []otlpmetrichttp.Option {
+	options := []otlpmetrichttp.Option{
+		otlpmetrichttp.WithEndpoint(cfg.Endpoint.Host),
+	}
 
-	metricExporter, err := otlpmetricgrpc.New(ctx, grpcMetricOptions()...)
-	if err != nil {
-		slog.Warn("Unable to initialize OTEL metric metricExporter", config.ErrAttr(err))
-		return nil, err
+	if cfg.Compressor == "gzip" {
+		options = append(options, otlpmetrichttp.WithCompression(otlpmetrichttp.GzipCompression))
+	}
+	if cfg.ExporterInsecure() {
+		options = append(options, otlpmetrichttp.WithInsecure())
 	}
 
-	meterProvider := metric.NewMeterProvider(
-		metric.WithReader(
-			metric.NewPeriodicReader(metricExporter, metric.WithInterval(config.OTELMeterInterval)),
-		),
+	return options
+}
+
+// newMetricExporter creates the exporter selected by OTEL_METRICS_EXPORTER
+func newMetricExporter(ctx context.Context, cfg config.OTelConfig) (metric.Exporter, error) {
+	switch cfg.MetricsExporter {
+	case config.ExporterOTLP:
+		if cfg.Protocol == config.ProtocolHTTPProtobuf {
+			return otlpmetrichttp.New(ctx, httpMetricOptions(cfg)...)
+		}
+		return otlpmetricgrpc.New(ctx, grpcMetricOptions(cfg)...)
+	case config.ExporterConsole:
+		return stdoutmetric.New()
+	case config.ExporterFile:
+		file, err := openExportFile(cfg, "metrics")
+		if err != nil {
+			return nil, err
+		}
+		exporter, err := stdoutmetric.New(stdoutmetric.WithWriter(file))
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		return fileMetricExporter{Exporter: exporter, file: file}, nil
+	default:
+		return nil, fmt.Errorf("no metric exporter for '%s'", cfg.MetricsExporter)
+	}
+}
+
+// InitializeMetricProvider exports the metrics periodically as OTEL_METRICS_EXPORTER selects (unless none) and
+// to the additional readers, e.g. the Prometheus one
+// https://opentelemetry.io/docs/languages/go/instrumentation/#metrics
+func InitializeMetricProvider(ctx context.Context, cfg config.OTelConfig, readers ...metric.Reader) (*metric.MeterProvider, error) {
+	options := []metric.Option{
 		metric.WithResource(
-			resource.NewWithAttributes(
-				semconv.SchemaURL,
-				semconv.TelemetrySDKLanguageGo,
-				semconv.ServiceName(config.ServiceName),
-				semconv.ServiceVersion(config.ServiceVersion),
-				semconv.HostNameKey.String(config.Hostname),
-				semconv.ProcessPIDKey.Int64(int64(os.Getpid())),
-			),
+			newResource(cfg),
 		),
-	)
+		metric.WithView(httpMetricViews(cfg)...),
+	}
+
+	if cfg.MetricsExporter != config.ExporterNone {
+		metricExporter, err := newMetricExporter(ctx, cfg)
+		if err != nil {
+			slog.WarnContext(ctx, "Unable to initialize OTEL metric exporter", config.ErrAttr(err))
+			return nil, err
+		}
+		options = append(options, metric.WithReader(
+			metric.NewPeriodicReader(metricExporter, metric.WithInterval(cfg.MetricInterval)),
+		))
+	}
+
+	for _, reader := range readers {
+		options = append(options, metric.WithReader(reader))
+	}
+
+	meterProvider := metric.NewMeterProvider(options...)
 
 	// set the global meter provider
 	otel.SetMeterProvider(meterProvider)
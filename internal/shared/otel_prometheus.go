@@ -0,0 +1,53 @@
+package shared
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	otelprometheus "go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"net/http"
+	"os"
+)
+
+// the SDK only records exemplars behind this experimental feature flag, read as each instrument is created
+const exemplarFeatureEnv = "OTEL_GO_X_EXEMPLAR"
+
+// NewPrometheusReader creates a pull reader for the MeterProvider and the handler serving what it collects (along
+// with the Go runtime and process metrics) in the Prometheus text or OpenMetrics format. With OpenMetrics the
+// histogram buckets and counters carry exemplars with the trace and span IDs of a sampled request, unless
+// OTEL_GO_X_EXEMPLAR is explicitly set to something other than true.
+func NewPrometheusReader() (metric.Reader, http.Handler, error) {
+	if _, ok := os.LookupEnv(exemplarFeatureEnv); !ok {
+		if err := os.Setenv(exemplarFeatureEnv, "true"); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	reader, err := otelprometheus.New(otelprometheus.WithRegisterer(registry))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+		ErrorLog:          promErrorLog{},
+		EnableOpenMetrics: true,
+	})
+	return reader, handler, nil
+}
+
+// promErrorLog reports the errors collecting a scrape to the OTEL error handler, so they count against the
+// telemetry health check
+type promErrorLog struct{}
+
+func (promErrorLog) Println(v ...interface{}) {
+	otel.Handle(fmt.Errorf("prometheus: %s", fmt.Sprint(v...)))
+}
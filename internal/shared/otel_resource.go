@@ -0,0 +1,31 @@
+package shared
+
+import (
+	"github.com/ssherwood/ysqlapp/internal/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"os"
+	"sort"
+)
+
+// newResource describes this service instance to every OTEL provider, OTEL_RESOURCE_ATTRIBUTES adds to (but never
+// replaces) the service identity
+func newResource(cfg config.OTelConfig) *resource.Resource {
+	attributes := make([]attribute.KeyValue, 0, len(cfg.ResourceAttributes))
+	for key, value := range cfg.ResourceAttributes {
+		attributes = append(attributes, attribute.String(key, value))
+	}
+	sort.Slice(attributes, func(i, j int) bool { return attributes[i].Key < attributes[j].Key })
+
+	return resource.NewWithAttributes(
+		semconv.SchemaURL,
+		append(attributes,
+			semconv.TelemetrySDKLanguageGo,
+			semconv.ServiceName(config.ServiceName),
+			semconv.ServiceVersion(config.ServiceVersion),
+			semconv.HostNameKey.String(config.Hostname),
+			semconv.ProcessPIDKey.Int64(int64(os.Getpid())),
+		)...,
+	)
+}
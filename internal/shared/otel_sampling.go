@@ -0,0 +1,308 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"github.com/ssherwood/ysqlapp/internal/config"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// bounds of the tail sampling buffer, traces beyond them are discarded rather than growing the heap
+	maxBufferedTraces   = 2048
+	maxSpansPerTrace    = 512
+	bufferedTraceMaxAge = time.Minute
+)
+
+// policySampler samples as OTEL_TRACES_SAMPLER (and its ARG and RULES) of the current config, the policy is
+// rebuilt whenever the configuration is changed at runtime. With OTEL_TRACES_SAMPLE_ERRORS or
+// OTEL_TRACES_SLOW_THRESHOLD a trace that is not sampled is still recorded, so the tail sampling processor can
+// keep it when it fails or is slow, unless a rule says it is never sampled.
+type policySampler struct {
+	policy atomic.Pointer[samplingPolicy]
+}
+
+type samplingPolicy struct {
+	cfg         *config.Config
+	parentBased bool
+	root        func(p trace.SamplingParameters) (decision trace.SamplingDecision, never bool)
+	tail        bool
+	description string
+}
+
+func (s *policySampler) ShouldSample(p trace.SamplingParameters) trace.SamplingResult {
+	policy := s.current()
+	parent := oteltrace.SpanContextFromContext(p.ParentContext)
+	result := trace.SamplingResult{Tracestate: parent.TraceState()}
+
+	if parent.IsValid() && policy.parentBased {
+		switch {
+		case parent.IsSampled():
+			result.Decision = trace.RecordAndSample
+		case !parent.IsRemote() && oteltrace.SpanFromContext(p.ParentContext).IsRecording():
+			// the local root was recorded for tail sampling, so is the rest of its trace
+			result.Decision = trace.RecordOnly
+		default:
+			result.Decision = trace.Drop
+		}
+		return result
+	}
+
+	decision, never := policy.root(p)
+	if decision == trace.Drop && policy.tail && !never {
+		decision = trace.RecordOnly
+	}
+	result.Decision = decision
+	return result
+}
+
+func (s *policySampler) Description() string {
+	return s.current().description
+}
+
+func (s *policySampler) current() *samplingPolicy {
+	cfg := config.Current()
+	policy := s.policy.Load()
+	if policy == nil || policy.cfg != cfg {
+		policy = newSamplingPolicy(cfg)
+		s.policy.Store(policy)
+	}
+	return policy
+}
+
+func newSamplingPolicy(cfg *config.Config) *samplingPolicy {
+	otelCfg := cfg.OTel
+	policy := &samplingPolicy{
+		cfg:  cfg,
+		tail: otelCfg.SampleErrors || otelCfg.SlowThreshold > 0,
+	}
+
+	ratio := trace.TraceIDRatioBased(otelCfg.SamplerRatio)
+	sample := func(sampler trace.Sampler) func(trace.SamplingParameters) (trace.SamplingDecision, bool) {
+		return func(p trace.SamplingParameters) (trace.SamplingDecision, bool) {
+			return sampler.ShouldSample(p).Decision, false
+		}
+	}
+
+	switch otelCfg.Sampler {
+	case config.SamplerAlwaysOn, config.SamplerParentBasedAlwaysOn:
+		policy.root = sample(trace.AlwaysSample())
+	case config.SamplerAlwaysOff, config.SamplerParentBasedAlwaysOff:
+		// nothing to tail sample when tracing is off
+		policy.root = sample(trace.NeverSample())
+		policy.tail = false
+	case config.SamplerRules:
+		policy.root = rulesSampler(otelCfg.SamplingRules(), ratio)
+	default:
+		policy.root = sample(ratio)
+	}
+
+	switch otelCfg.Sampler {
+	case config.SamplerAlwaysOn, config.SamplerAlwaysOff, config.SamplerTraceIDRatio:
+	default:
+		policy.parentBased = true
+	}
+
+	policy.description = fmt.Sprintf("Policy{sampler=%s,arg=%g,rules=%d,tail=%t}",
+		otelCfg.Sampler, otelCfg.SamplerRatio, len(otelCfg.SamplerRules), policy.tail)
+	return policy
+}
+
+// rulesSampler samples a root span by the first rule matching its HTTP method and route, by the fallback sampler
+// when none does (e.g. a span that is not an HTTP request)
+func rulesSampler(rules []config.SamplingRule, fallback trace.Sampler) func(trace.SamplingParameters) (trace.SamplingDecision, bool) {
+	samplers := make([]trace.Sampler, len(rules))
+	for i, rule := range rules {
+		samplers[i] = trace.TraceIDRatioBased(rule.Ratio)
+	}
+
+	return func(p trace.SamplingParameters) (trace.SamplingDecision, bool) {
+		var method, route string
+		for _, attr := range p.Attributes {
+			switch attr.Key {
+			case semconv.HTTPRequestMethodKey, "http.method":
+				method = attr.Value.AsString()
+			case semconv.HTTPRouteKey:
+				route = attr.Value.AsString()
+			}
+		}
+
+		if route != "" {
+			for i, rule := range rules {
+				if rule.Matches(method, route) {
+					return samplers[i].ShouldSample(p).Decision, rule.Ratio == 0
+				}
+			}
+		}
+		return fallback.ShouldSample(p).Decision, false
+	}
+}
+
+// tailSamplingProcessor passes sampled spans on to the exporting processor and buffers the spans of recorded but
+// unsampled traces until their local root ends. The trace is then exported when any of its spans failed (with
+// OTEL_TRACES_SAMPLE_ERRORS) or the root took OTEL_TRACES_SLOW_THRESHOLD or more, otherwise discarded. Only this
+// service's spans are kept, the services it called did not sample the trace.
+type tailSamplingProcessor struct {
+	next trace.SpanProcessor
+
+	mu     sync.Mutex
+	traces map[oteltrace.TraceID]*bufferedTrace
+
+	kept      atomic.Int64
+	discarded atomic.Int64
+	overflow  atomic.Int64
+}
+
+type bufferedTrace struct {
+	startedAt time.Time
+	spans     []trace.ReadOnlySpan
+	keep      bool
+}
+
+func newTailSamplingProcessor(next trace.SpanProcessor) *tailSamplingProcessor {
+	return &tailSamplingProcessor{next: next, traces: make(map[oteltrace.TraceID]*bufferedTrace)}
+}
+
+func (p *tailSamplingProcessor) OnStart(parent context.Context, s trace.ReadWriteSpan) {
+	p.next.OnStart(parent, s)
+}
+
+func (p *tailSamplingProcessor) OnEnd(s trace.ReadOnlySpan) {
+	if s.SpanContext().IsSampled() {
+		p.next.OnEnd(s)
+		return
+	}
+
+	cfg := config.Current().OTel
+	isRoot := !s.Parent().IsValid() || s.Parent().IsRemote()
+	keep := (cfg.SampleErrors && s.Status().Code == codes.Error) ||
+		(isRoot && cfg.SlowThreshold > 0 && s.EndTime().Sub(s.StartTime()) >= cfg.SlowThreshold)
+
+	traceID := s.SpanContext().TraceID()
+	p.mu.Lock()
+	buffered, ok := p.traces[traceID]
+	if !ok {
+		if len(p.traces) >= maxBufferedTraces && !p.evictLocked() {
+			p.mu.Unlock()
+			p.overflow.Add(1)
+			return
+		}
+		buffered = &bufferedTrace{startedAt: time.Now()}
+		p.traces[traceID] = buffered
+	}
+	if len(buffered.spans) < maxSpansPerTrace {
+		buffered.spans = append(buffered.spans, s)
+	}
+	buffered.keep = buffered.keep || keep
+	if isRoot {
+		delete(p.traces, traceID)
+	}
+	p.mu.Unlock()
+
+	if !isRoot {
+		return
+	}
+	if !buffered.keep {
+		p.discarded.Add(1)
+		return
+	}
+
+	p.kept.Add(1)
+	for _, span := range buffered.spans {
+		p.next.OnEnd(tailSampledSpan{span})
+	}
+}
+
+// evictLocked drops the traces whose root never ended (e.g. a span leaked by its caller), reporting whether
+// there is now room for another
+func (p *tailSamplingProcessor) evictLocked() bool {
+	for traceID, buffered := range p.traces {
+		if time.Since(buffered.startedAt) > bufferedTraceMaxAge {
+			delete(p.traces, traceID)
+			p.discarded.Add(1)
+		}
+	}
+	return len(p.traces) < maxBufferedTraces
+}
+
+func (p *tailSamplingProcessor) Shutdown(ctx context.Context) error {
+	return p.next.Shutdown(ctx)
+}
+
+func (p *tailSamplingProcessor) ForceFlush(ctx context.Context) error {
+	return p.next.ForceFlush(ctx)
+}
+
+// tailSampledSpan marks a buffered span sampled, exporting processors drop the spans that are not
+type tailSampledSpan struct {
+	trace.ReadOnlySpan
+}
+
+func (s tailSampledSpan) SpanContext() oteltrace.SpanContext {
+	spanContext := s.ReadOnlySpan.SpanContext()
+	return spanContext.WithTraceFlags(spanContext.TraceFlags().WithSampled(true))
+}
+
+// SamplingReport is the effective trace sampling policy and what tail sampling has done so far
+type SamplingReport struct {
+	Active        bool                  `json:"active"`
+	Sampler       string                `json:"sampler"`
+	Description   string                `json:"description"`
+	Ratio         float64               `json:"ratio"`
+	ParentBased   bool                  `json:"parent_based"`
+	Rules         []config.SamplingRule `json:"rules,omitempty"`
+	SampleErrors  bool                  `json:"sample_errors"`
+	SlowThreshold string                `json:"slow_threshold"`
+	TailSampling  bool                  `json:"tail_sampling"`
+	Buffered      int                   `json:"buffered_traces"`
+	Kept          int64                 `json:"kept_traces"`
+	Discarded     int64                 `json:"discarded_traces"`
+	Overflow      int64                 `json:"overflow_spans"`
+}
+
+// the sampler and processor of the tracer provider, nil until InitTracerProvider
+var (
+	activeSampler   atomic.Pointer[policySampler]
+	activeProcessor atomic.Pointer[tailSamplingProcessor]
+)
+
+// SamplingPolicy reports the sampling policy of the current configuration, Active is false when no tracer
+// provider applies it
+func SamplingPolicy() SamplingReport {
+	sampler := activeSampler.Load()
+	if sampler == nil {
+		sampler = &policySampler{}
+	}
+	policy := sampler.current()
+	cfg := policy.cfg.OTel
+
+	report := SamplingReport{
+		Active:        activeSampler.Load() != nil,
+		Sampler:       cfg.Sampler,
+		Description:   policy.description,
+		Ratio:         cfg.SamplerRatio,
+		ParentBased:   policy.parentBased,
+		SampleErrors:  cfg.SampleErrors,
+		SlowThreshold: cfg.SlowThreshold.String(),
+		TailSampling:  policy.tail,
+	}
+	if cfg.Sampler == config.SamplerRules {
+		report.Rules = cfg.SamplingRules()
+	}
+
+	if processor := activeProcessor.Load(); processor != nil {
+		processor.mu.Lock()
+		report.Buffered = len(processor.traces)
+		processor.mu.Unlock()
+		report.Kept = processor.kept.Load()
+		report.Discarded = processor.discarded.Load()
+		report.Overflow = processor.overflow.Load()
+	}
+	return report
+}
@@ -2,25 +2,25 @@ package shared
 
 import (
 	"context"
+	"fmt"
 	"github.com/ssherwood/ysqlapp/internal/config"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
 	"go.opentelemetry.io/otel/propagation"
-	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
 	"google.golang.org/grpc/credentials"
 	"log/slog"
-	"os"
 )
 
-func grpcTracerOptions() []otlptracegrpc.Option {
+func grpcTracerOptions(cfg config.OTelConfig) []otlptracegrpc.Option {
 	options := []otlptracegrpc.Option{
-		otlptracegrpc.WithEndpoint(config.OTELCollectorURL),
-		otlptracegrpc.WithCompressor(config.OTELCompressor),
+		otlptracegrpc.WithEndpoint(cfg.Endpoint.Host),
+		otlptracegrpc.WithCompressor(cfg.Compressor),
 	}
 
-	if config.OTELExporterInsecure {
+	if cfg.ExporterInsecure() {
 		options = append(options, otlptracegrpc.WithInsecure())
 	} else {
 		options = append(options,
@@ -31,29 +31,66 @@ func grpcTracerOptions() []otlptracegrpc.Option {
 	return options
 }
 
+func httpTracerOptions(cfg config.OTelConfig) []otlptracehttp.Option {
+	options := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(cfg.Endpoint.Host),
+	}
+
+	if cfg.Compressor == "gzip" {
+		options = append(options, otlptracehttp.WithCompression(otlptracehttp.GzipCompression))
+	}
+	if cfg.ExporterInsecure() {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+
+	return options
+}
+
+// newSpanExporter creates the exporter selected by OTEL_TRACES_EXPORTER
+func newSpanExporter(ctx context.Context, cfg config.OTelConfig) (trace.SpanExporter, error) {
+	switch cfg.TracesExporter {
+	case config.ExporterOTLP:
+		if cfg.Protocol == config.ProtocolHTTPProtobuf {
+			return otlptracehttp.New(ctx, httpTracerOptions(cfg)...)
+		}
+		return otlptracegrpc.New(ctx, grpcTracerOptions(cfg)...)
+	case config.ExporterConsole:
+		return stdouttrace.New()
+	case config.ExporterFile:
+		file, err := openExportFile(cfg, "traces")
+		if err != nil {
+			return nil, err
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		return fileSpanExporter{SpanExporter: exporter, file: file}, nil
+	default:
+		return nil, fmt.Errorf("no trace exporter for '%s'", cfg.TracesExporter)
+	}
+}
+
 // InitTracerProvider
 // https://opentelemetry.io/docs/languages/go/instrumentation/#traces
-func InitTracerProvider(ctx context.Context) (*trace.TracerProvider, error) {
-	traceExporter, err := otlptracegrpc.New(ctx, grpcTracerOptions()...)
+func InitTracerProvider(ctx context.Context, cfg config.OTelConfig) (*trace.TracerProvider, error) {
+	traceExporter, err := newSpanExporter(ctx, cfg)
 	if err != nil {
-		slog.Warn("Unable to initialize OTEL trace exporter", config.ErrAttr(err))
+		slog.WarnContext(ctx, "Unable to initialize OTEL trace exporter", config.ErrAttr(err))
 		return nil, err
 	}
 
+	sampler := &policySampler{}
+	processor := newTailSamplingProcessor(trace.NewBatchSpanProcessor(traceExporter))
+	activeSampler.Store(sampler)
+	activeProcessor.Store(processor)
+
 	tracerProvider := trace.NewTracerProvider(
-		trace.WithBatcher(traceExporter),
-		trace.WithSampler(trace.AlwaysSample()), // TODO config
-		// e.g. set the sampling rate based on the parent span to 60%
-		// trace.WithSampler(trace.ParentBased(trace.TraceIDRatioBased(0.6))),
+		trace.WithSpanProcessor(processor),
+		trace.WithSampler(sampler),
 		trace.WithResource(
-			resource.NewWithAttributes(
-				semconv.SchemaURL,
-				semconv.TelemetrySDKLanguageGo,
-				semconv.ServiceNameKey.String(config.ServiceName),
-				semconv.ServiceVersion(config.ServiceVersion),
-				semconv.HostNameKey.String(config.Hostname),
-				semconv.ProcessPIDKey.Int64(int64(os.Getpid())),
-			),
+			newResource(cfg),
 		),
 	)
 
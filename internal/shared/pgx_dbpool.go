@@ -11,20 +11,27 @@ import (
 	"log/slog"
 	"regexp"
 	"strings"
+	"time"
 )
 
-func InitializeDB(ctx context.Context) (*pgxpool.Pool, error) {
-	poolConfig, configErr := pgxPoolConfig()
+func InitializeDB(ctx context.Context, cfg *config.Config) (*pgxpool.Pool, error) {
+	poolMeter, meterErr := NewPoolMeter(cfg.DB.PoolName)
+	if meterErr != nil {
+		slog.ErrorContext(ctx, "Unable to create pgx connection pool metrics", config.ErrAttr(meterErr))
+		return nil, meterErr
+	}
+
+	poolConfig, configErr := pgxPoolConfig(ctx, cfg, poolMeter)
 	if configErr != nil {
 		return nil, configErr
 	}
 
 	//pgxpool.ParseConfig()
 	if dbPool, poolErr := pgxpool.NewWithConfig(ctx, poolConfig); poolErr != nil {
-		slog.Error("Unable to create pgx connection pool", config.ErrAttr(poolErr))
+		slog.ErrorContext(ctx, "Unable to create pgx connection pool", config.ErrAttr(poolErr))
 		return nil, poolErr
 	} else {
-		_ = InitPgxPoolMeter(dbPool)
+		_ = poolMeter.Observe(ctx, dbPool)
 		return dbPool, nil
 	}
 }
@@ -32,11 +39,11 @@ func InitializeDB(ctx context.Context) (*pgxpool.Pool, error) {
 func PingDB(ctx context.Context, pool *pgxpool.Pool) error {
 	connection, err := pool.Acquire(ctx)
 	if err != nil {
-		slog.Error("Unable to acquire a connection from the database pool", "error", err, "config", pool.Config())
+		slog.ErrorContext(ctx, "Unable to acquire a connection from the database pool", "error", err, "config", pool.Config())
 		return err
 	} else {
 		if err = connection.Ping(ctx); err != nil {
-			slog.Error("Could not ping database", "error", err, "config", pool.Config())
+			slog.ErrorContext(ctx, "Could not ping database", "error", err, "config", pool.Config())
 			return err
 		}
 	}
@@ -45,72 +52,96 @@ func PingDB(ctx context.Context, pool *pgxpool.Pool) error {
 	return nil
 }
 
-func pgxPoolConfig() (*pgxpool.Config, error) {
+// DrainPool waits for the connections in use (in-flight queries and transactions) to be released and closes the
+// pool. When the context is done first the pool is closed in the background and the connections still in use are
+// reported, their transactions are rolled back by the server once the process exits.
+func DrainPool(ctx context.Context, pool *pgxpool.Pool) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for pool.Stat().AcquiredConns() > 0 {
+		select {
+		case <-ctx.Done():
+			inUse := pool.Stat().AcquiredConns()
+			go pool.Close()
+			return fmt.Errorf("%d connections still in use: %w", inUse, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+
+	pool.Close()
+	return nil
+}
+
+func pgxPoolConfig(ctx context.Context, cfg *config.Config, poolMeter *PoolMeter) (*pgxpool.Config, error) {
 	url := fmt.Sprintf("postgres://%s:%s@%s/%s?%s",
-		config.DBUserName, config.DBPassword, config.DBHostname, config.DBDatabase,
+		cfg.DB.Username, cfg.DB.Password, strings.Join(cfg.DB.Hosts, ","), cfg.DB.Database,
 		mapToOptions(
 			map[string]string{
-				"sslmode":           config.DBSSLMode,
-				"statement_timeout": config.DBStatementTimeout.String(),
-				//"load_balance":      config.DBYSQLLoadBalance,
-				//"topology_keys":     config.DBYSQLTopologyKeys,
+				"sslmode":           cfg.DB.SSLMode,
+				"statement_timeout": cfg.DB.StatementTimeout.String(),
+				//"load_balance":      strconv.FormatBool(cfg.DB.LoadBalance),
+				//"topology_keys":     strings.Join(cfg.DB.TopologyKeys, ","),
 			},
 		),
 	)
 
 	poolConfig, err := pgxpool.ParseConfig(url)
 	if err != nil {
-		slog.Warn("Failed to parse pgxpool url", config.ErrAttr(err))
+		slog.WarnContext(ctx, "Failed to parse pgxpool url", config.ErrAttr(err))
 		return nil, err
 	}
 
-	poolConfig.MaxConns = config.DBMaxConns
-	poolConfig.MinConns = config.DBMinConns
-	poolConfig.MaxConnLifetime = config.DBMaxConnLifetime
-	poolConfig.MaxConnLifetimeJitter = config.DBMaxConnLifetimeJitter
-	poolConfig.HealthCheckPeriod = config.DBHealthCheckPeriod
-	poolConfig.ConnConfig.ConnectTimeout = config.DBConnectTimeout
+	poolConfig.MaxConns = cfg.DB.MaxConns
+	poolConfig.MinConns = cfg.DB.MinConns
+	poolConfig.MaxConnLifetime = cfg.DB.MaxConnLifetime
+	poolConfig.MaxConnLifetimeJitter = cfg.DB.MaxConnLifetimeJitter
+	poolConfig.HealthCheckPeriod = cfg.DB.HealthCheckPeriod
+	poolConfig.ConnConfig.ConnectTimeout = cfg.DB.ConnectTimeout
+	poolConfig.ConnConfig.RuntimeParams["application_name"] = instanceApplicationName(cfg.DB.ApplicationName)
 
-	//	poolConfig.
 	poolConfig.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
-		// your expensive query here (add pg_sleep(5) to simulate long delay)
-		_ = conn.QueryRow(ctx, "select * from location loc left join address adr on loc.address_id = adr.id where loc.id='f9654e2a-dc0d-4423-8291-000000004448' and loc.active=true order by loc.id desc limit 1").Scan()
-		slog.Info("AfterConnect")
+		slog.DebugContext(ctx, "Connected a new pool connection", "host", conn.Config().Host)
+		poolConnections.connected(conn)
 		return nil
 	}
 
-	poolConfig.BeforeAcquire = defaultBeforeAcquireFn()
-	poolConfig.AfterRelease = defaultAfterReleaseFn()
+	poolConfig.BeforeAcquire = defaultBeforeAcquireFn(poolMeter)
+	poolConfig.AfterRelease = defaultAfterReleaseFn(poolMeter)
 	poolConfig.BeforeClose = defaultBeforeCloseFn()
 
-	if config.OTELTracerEnabled {
-		poolConfig.ConnConfig.Tracer = NewQueryTracer([]attribute.KeyValue{
-			semconv.DBSystemKey.String("yugabytedb"),
-			semconv.DBConnectionStringKey.String(maskPostgresPassword(url)),
-			semconv.ServerAddress(config.Hostname),
-		})
-	}
+	// installed regardless of OTEL_TRACER_ENABLE so the queries are always measured
+	poolConfig.ConnConfig.Tracer = NewQueryTracer(ctx, cfg.OTel, []attribute.KeyValue{
+		semconv.DBSystemKey.String("yugabytedb"),
+		semconv.DBConnectionStringKey.String(maskPostgresPassword(url)),
+		semconv.ServerAddress(config.Hostname),
+	})
 
 	return poolConfig, nil
 }
 
-func defaultBeforeAcquireFn() func(ctx context.Context, c *pgx.Conn) bool {
+func defaultBeforeAcquireFn(poolMeter *PoolMeter) func(ctx context.Context, c *pgx.Conn) bool {
 	return func(ctx context.Context, c *pgx.Conn) bool {
-		slog.Debug("Before acquiring a database connection from the pool")
+		slog.DebugContext(ctx, "Before acquiring a database connection from the pool")
+		poolConnections.acquired(c)
+		poolMeter.acquired(ctx)
 
 		if slog.Default().Enabled(ctx, slog.LevelDebug) {
 			var value string
 			_ = c.QueryRow(ctx, "select current_setting('yb_read_from_followers')").Scan(&value)
-			slog.Debug("Checking current_setting of yb_read_from_followers", "yb_read_from_followers", value)
+			slog.DebugContext(ctx, "Checking current_setting of yb_read_from_followers", "yb_read_from_followers", value)
 		}
 
 		return true
 	}
 }
 
-func defaultAfterReleaseFn() func(c *pgx.Conn) bool {
+func defaultAfterReleaseFn(poolMeter *PoolMeter) func(c *pgx.Conn) bool {
 	return func(c *pgx.Conn) bool {
 		slog.Debug("After releasing database connection back to the pool")
+		if inUse, ok := poolConnections.released(c); ok {
+			poolMeter.released(inUse)
+		}
 
 		if slog.Default().Enabled(context.Background(), slog.LevelDebug) {
 			var value string
@@ -125,7 +156,18 @@ func defaultAfterReleaseFn() func(c *pgx.Conn) bool {
 func defaultBeforeCloseFn() func(c *pgx.Conn) {
 	return func(c *pgx.Conn) {
 		slog.Debug("Closed database connection", "host", c.Config().Host)
+		poolConnections.closed(c)
+	}
+}
+
+// instanceApplicationName qualifies the application_name with the host so each instance's sessions can be told
+// apart in pg_stat_activity, Postgres truncates it to 63 bytes
+func instanceApplicationName(name string) string {
+	qualified := name + "@" + config.Hostname
+	if len(qualified) > 63 {
+		qualified = qualified[:63]
 	}
+	return qualified
 }
 
 func mapToOptions(params map[string]string) string {
@@ -2,48 +2,191 @@ package shared
 
 import (
 	"context"
+	"errors"
 	"github.com/ssherwood/ysqlapp/internal/config"
+	"github.com/yugabyte/pgx/v5"
+	"github.com/yugabyte/pgx/v5/pgconn"
 	"github.com/yugabyte/pgx/v5/pgxpool"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
 	"log/slog"
+	"time"
 )
 
-// InitPgxPoolMeter
-// given a pgxpool.Pool, build a databaseMeter callback for the available statistics available.
-func InitPgxPoolMeter(dbPool *pgxpool.Pool) error {
-	var databaseMeter = otel.Meter("github.com/yugabyte/pgx/v5/pgxpool",
+const (
+	// DestroyReasonKey represents why the pool closed a connection.
+	DestroyReasonKey = attribute.Key("db.client.connection.destroy_reason")
+)
+
+// the buckets of the connection use and wait time histograms (seconds)
+var poolTimeBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+// PoolMeter records the metrics of a pgxpool.Pool named by its pool.name attribute, following the
+// db.client.connection.* semantic conventions. The pool statistics are observed on every collection, the use of
+// each connection as it is released through the pool hooks and the wait for each connection acquired through a
+// MeteredPool.
+type PoolMeter struct {
+	name     string
+	attrs    metric.MeasurementOption
+	meter    metric.Meter
+	useTime  metric.Float64Histogram
+	waitTime metric.Float64Histogram
+}
+
+// MeteredPool is the pool the repositories use, it passes when each call started acquiring a connection to the
+// BeforeAcquire hook in the context so the wait is recorded in the wait_time histogram (pgxpool has no hook where
+// an acquire starts).
+type MeteredPool struct {
+	*pgxpool.Pool
+}
+
+type acquireStartKey struct{}
+
+func acquireStarted(ctx context.Context) context.Context {
+	return context.WithValue(ctx, acquireStartKey{}, time.Now())
+}
+
+func (p *MeteredPool) Acquire(ctx context.Context) (*pgxpool.Conn, error) {
+	return p.Pool.Acquire(acquireStarted(ctx))
+}
+
+func (p *MeteredPool) Begin(ctx context.Context) (pgx.Tx, error) {
+	return p.Pool.Begin(acquireStarted(ctx))
+}
+
+func (p *MeteredPool) BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error) {
+	return p.Pool.BeginTx(acquireStarted(ctx), txOptions)
+}
+
+func (p *MeteredPool) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return p.Pool.Exec(acquireStarted(ctx), sql, args...)
+}
+
+func (p *MeteredPool) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return p.Pool.Query(acquireStarted(ctx), sql, args...)
+}
+
+func (p *MeteredPool) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return p.Pool.QueryRow(acquireStarted(ctx), sql, args...)
+}
+
+// NewPoolMeter creates the instruments of the named pool, its statistics are observed once Observe is given the
+// pool built with its hooks
+func NewPoolMeter(name string) (*PoolMeter, error) {
+	meter := otel.Meter("github.com/yugabyte/pgx/v5/pgxpool",
 		metric.WithInstrumentationAttributes(
 			semconv.ServiceName(config.ServiceName),
 		),
 	)
 
-	idleConns, _ := databaseMeter.Int64ObservableGauge("pgxpool.idleConns")
-	totalConns, _ := databaseMeter.Int64ObservableGauge("pgxpool.totalConns")
-	acquireCount, _ := databaseMeter.Int64ObservableCounter("pgxpool.acquireCount")
-	newConnsCount, _ := databaseMeter.Int64ObservableCounter("pgxpool.newConnsCount")
-	maxLifetimeDestroyCount, _ := databaseMeter.Int64ObservableCounter("pgxpool.maxLifetimeDestroyCount")
-	acquireDuration, _ := databaseMeter.Int64ObservableGauge("pgxpool.acquireDuration", metric.WithUnit("ms"))
-	// TODO finish remaining metrics...
+	useTime, err := meter.Float64Histogram("db.client.connection.use_time",
+		metric.WithDescription("The time between borrowing a connection and returning it to the pool."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(poolTimeBuckets...))
+	if err != nil {
+		return nil, err
+	}
+
+	waitTime, err := meter.Float64Histogram("db.client.connection.wait_time",
+		metric.WithDescription("The time it took to obtain an open connection from the pool."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(poolTimeBuckets...))
+	if err != nil {
+		return nil, err
+	}
+
+	return &PoolMeter{
+		name:     name,
+		attrs:    metric.WithAttributeSet(attribute.NewSet(semconv.PoolName(name))),
+		meter:    meter,
+		useTime:  useTime,
+		waitTime: waitTime,
+	}, nil
+}
+
+// Observe registers the callback observing the pool statistics
+func (m *PoolMeter) Observe(ctx context.Context, pool *pgxpool.Pool) error {
+	minConns := pool.Config().MinConns
 
-	_, err := databaseMeter.RegisterCallback(
+	count, err1 := m.meter.Int64ObservableUpDownCounter("db.client.connection.count",
+		metric.WithDescription("The number of connections that are currently in state described by the state attribute."),
+		metric.WithUnit("{connection}"))
+	constructing, err2 := m.meter.Int64ObservableUpDownCounter("db.client.connection.constructing",
+		metric.WithDescription("The number of connections that are being established."),
+		metric.WithUnit("{connection}"))
+	maxConns, err3 := m.meter.Int64ObservableUpDownCounter("db.client.connection.max",
+		metric.WithDescription("The maximum number of open connections allowed."),
+		metric.WithUnit("{connection}"))
+	idleMin, err4 := m.meter.Int64ObservableUpDownCounter("db.client.connection.idle.min",
+		metric.WithDescription("The minimum number of idle open connections allowed."),
+		metric.WithUnit("{connection}"))
+	acquires, err5 := m.meter.Int64ObservableCounter("db.client.connection.acquires",
+		metric.WithDescription("The number of connections acquired from the pool."),
+		metric.WithUnit("{acquire}"))
+	emptyAcquires, err6 := m.meter.Int64ObservableCounter("db.client.connection.acquires.empty",
+		metric.WithDescription("The number of acquires that waited for a connection because none was idle."),
+		metric.WithUnit("{acquire}"))
+	acquireTime, err7 := m.meter.Float64ObservableCounter("db.client.connection.acquire_time",
+		metric.WithDescription("The total time spent obtaining connections from the pool, its rate over the rate of acquires is the average wait."),
+		metric.WithUnit("s"))
+	timeouts, err8 := m.meter.Int64ObservableCounter("db.client.connection.timeouts",
+		metric.WithDescription("The number of acquires canceled (e.g. timed out) before a connection was obtained."),
+		metric.WithUnit("{timeout}"))
+	created, err9 := m.meter.Int64ObservableCounter("db.client.connection.created",
+		metric.WithDescription("The number of connections opened by the pool."),
+		metric.WithUnit("{connection}"))
+	destroyed, err10 := m.meter.Int64ObservableCounter("db.client.connection.destroyed",
+		metric.WithDescription("The number of connections closed by the pool, by the reason they were closed."),
+		metric.WithUnit("{connection}"))
+	if err := errors.Join(err1, err2, err3, err4, err5, err6, err7, err8, err9, err10); err != nil {
+		slog.ErrorContext(ctx, "failed to create pgxpool instruments", config.ErrAttr(err))
+		return err
+	}
+
+	poolName := semconv.PoolName(m.name)
+	idle := metric.WithAttributeSet(attribute.NewSet(poolName, semconv.StateIdle))
+	used := metric.WithAttributeSet(attribute.NewSet(poolName, semconv.StateUsed))
+	maxLifetime := metric.WithAttributeSet(attribute.NewSet(poolName, DestroyReasonKey.String("max_lifetime")))
+	maxIdle := metric.WithAttributeSet(attribute.NewSet(poolName, DestroyReasonKey.String("max_idle")))
+
+	_, err := m.meter.RegisterCallback(
 		func(_ context.Context, o metric.Observer) error {
-			dbStats := dbPool.Stat()
-			o.ObserveInt64(idleConns, int64(dbStats.IdleConns()))
-			o.ObserveInt64(totalConns, int64(dbStats.TotalConns()))
-			o.ObserveInt64(acquireCount, dbStats.AcquireCount())
-			o.ObserveInt64(newConnsCount, dbStats.NewConnsCount())
-			o.ObserveInt64(maxLifetimeDestroyCount, dbStats.MaxLifetimeDestroyCount())
-			o.ObserveInt64(acquireDuration, dbStats.AcquireDuration().Milliseconds())
+			stat := pool.Stat()
+			o.ObserveInt64(count, int64(stat.IdleConns()), idle)
+			o.ObserveInt64(count, int64(stat.AcquiredConns()), used)
+			o.ObserveInt64(constructing, int64(stat.ConstructingConns()), m.attrs)
+			o.ObserveInt64(maxConns, int64(stat.MaxConns()), m.attrs)
+			o.ObserveInt64(idleMin, int64(minConns), m.attrs)
+			o.ObserveInt64(acquires, stat.AcquireCount(), m.attrs)
+			o.ObserveInt64(emptyAcquires, stat.EmptyAcquireCount(), m.attrs)
+			o.ObserveFloat64(acquireTime, stat.AcquireDuration().Seconds(), m.attrs)
+			o.ObserveInt64(timeouts, stat.CanceledAcquireCount(), m.attrs)
+			o.ObserveInt64(created, stat.NewConnsCount(), m.attrs)
+			o.ObserveInt64(destroyed, stat.MaxLifetimeDestroyCount(), maxLifetime)
+			o.ObserveInt64(destroyed, stat.MaxIdleDestroyCount(), maxIdle)
 			return nil
 		},
-		idleConns, totalConns, acquireCount, newConnsCount, maxLifetimeDestroyCount, acquireDuration,
+		count, constructing, maxConns, idleMin, acquires, emptyAcquires, acquireTime, timeouts, created, destroyed,
 	)
 	if err != nil {
-		slog.Error("failed to register pgxpool stats", config.ErrAttr(err))
+		slog.ErrorContext(ctx, "failed to register pgxpool stats", config.ErrAttr(err))
 		return err
 	}
 
 	return nil
 }
+
+// acquired records how long the caller waited for the connection when it was acquired through a MeteredPool, called
+// from the BeforeAcquire hook
+func (m *PoolMeter) acquired(ctx context.Context) {
+	if start, ok := ctx.Value(acquireStartKey{}).(time.Time); ok {
+		m.waitTime.Record(ctx, time.Since(start).Seconds(), m.attrs)
+	}
+}
+
+// released records how long the connection was in use, called from the AfterRelease hook
+func (m *PoolMeter) released(inUse time.Duration) {
+	m.useTime.Record(context.Background(), inUse.Seconds(), m.attrs)
+}
@@ -0,0 +1,282 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"github.com/yugabyte/pgx/v5"
+	"github.com/yugabyte/pgx/v5/pgxpool"
+	"sort"
+	"sync"
+	"time"
+)
+
+// sessionSettings are reported for each pooled connection, a setting the server does not know (e.g. the yb_
+// settings on Postgres) is reported as empty
+var sessionSettings = []string{
+	"yb_read_from_followers",
+	"yb_follower_read_staleness_ms",
+	"default_transaction_read_only",
+	"statement_timeout",
+	"application_name",
+}
+
+// PoolStats is every pgxpool.Stat value of the pool
+type PoolStats struct {
+	MaxConns                int32   `json:"max_conns"`
+	TotalConns              int32   `json:"total_conns"`
+	AcquiredConns           int32   `json:"acquired_conns"`
+	IdleConns               int32   `json:"idle_conns"`
+	ConstructingConns       int32   `json:"constructing_conns"`
+	AcquireCount            int64   `json:"acquire_count"`
+	AcquireDurationMs       float64 `json:"acquire_duration_ms"`
+	EmptyAcquireCount       int64   `json:"empty_acquire_count"`
+	CanceledAcquireCount    int64   `json:"canceled_acquire_count"`
+	NewConnsCount           int64   `json:"new_conns_count"`
+	MaxLifetimeDestroyCount int64   `json:"max_lifetime_destroy_count"`
+	MaxIdleDestroyCount     int64   `json:"max_idle_destroy_count"`
+}
+
+// ConnInfo describes one pooled connection as seen through the pool hooks. Settings are read from idle
+// connections when the pool is inspected, for a connection in use they are the ones last read.
+type ConnInfo struct {
+	PID                uint32            `json:"pid"`
+	Host               string            `json:"host"`
+	RemoteAddress      string            `json:"remote_address"`
+	State              string            `json:"state"`
+	AgeMs              int64             `json:"age_ms"`
+	IdleMs             int64             `json:"idle_ms,omitempty"`
+	InUseMs            int64             `json:"in_use_ms,omitempty"`
+	AcquireCount       int64             `json:"acquire_count"`
+	Settings           map[string]string `json:"settings,omitempty"`
+	SettingsObservedAt *time.Time        `json:"settings_observed_at,omitempty"`
+}
+
+// PoolDiagnostics is the pool and each of its connections
+type PoolDiagnostics struct {
+	Stats       PoolStats  `json:"stats"`
+	Connections []ConnInfo `json:"connections"`
+}
+
+type connState struct {
+	createdAt    time.Time
+	acquiredAt   time.Time
+	releasedAt   time.Time
+	inUse        bool
+	acquireCount int64
+	settings     map[string]string
+	settingsAt   time.Time
+}
+
+// connTracker follows each pooled connection through the pool hooks
+type connTracker struct {
+	mu    sync.Mutex
+	conns map[*pgx.Conn]*connState
+}
+
+// poolConnections tracks the connections of the process's pool, every command creates at most one
+var poolConnections = &connTracker{conns: make(map[*pgx.Conn]*connState)}
+
+func (t *connTracker) connected(conn *pgx.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.conns[conn] = &connState{createdAt: now, releasedAt: now}
+}
+
+func (t *connTracker) acquired(conn *pgx.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if state, ok := t.conns[conn]; ok {
+		state.inUse = true
+		state.acquiredAt = time.Now()
+		state.acquireCount++
+	}
+}
+
+// released marks the connection idle, returning how long it was in use
+func (t *connTracker) released(conn *pgx.Conn) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if state, ok := t.conns[conn]; ok && state.inUse {
+		state.inUse = false
+		state.releasedAt = time.Now()
+		return state.releasedAt.Sub(state.acquiredAt), true
+	}
+	return 0, false
+}
+
+func (t *connTracker) closed(conn *pgx.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.conns, conn)
+}
+
+func (t *connTracker) observed(conn *pgx.Conn, settings map[string]string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if state, ok := t.conns[conn]; ok {
+		state.settings = settings
+		state.settingsAt = time.Now()
+	}
+}
+
+func (t *connTracker) snapshot() []ConnInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	infos := make([]ConnInfo, 0, len(t.conns))
+	for conn, state := range t.conns {
+		info := ConnInfo{
+			PID:          conn.PgConn().PID(),
+			Host:         conn.Config().Host,
+			State:        "idle",
+			AgeMs:        now.Sub(state.createdAt).Milliseconds(),
+			AcquireCount: state.acquireCount,
+			Settings:     state.settings,
+		}
+		if netConn := conn.PgConn().Conn(); netConn != nil {
+			info.RemoteAddress = netConn.RemoteAddr().String()
+		}
+		if state.inUse {
+			info.State = "in_use"
+			info.InUseMs = now.Sub(state.acquiredAt).Milliseconds()
+		} else {
+			info.IdleMs = now.Sub(state.releasedAt).Milliseconds()
+		}
+		if !state.settingsAt.IsZero() {
+			settingsAt := state.settingsAt
+			info.SettingsObservedAt = &settingsAt
+		}
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].PID < infos[j].PID })
+	return infos
+}
+
+// InspectPool reads the session settings of the idle connections and returns the pool stats along with every
+// connection. Connections in use are never touched, they report the settings last read from them.
+func InspectPool(ctx context.Context, pool *pgxpool.Pool) PoolDiagnostics {
+	for _, conn := range pool.AcquireAllIdle(ctx) {
+		settings := make(map[string]string, len(sessionSettings))
+		for _, name := range sessionSettings {
+			var value *string
+			if err := conn.QueryRow(ctx, "select current_setting($1, true)", name).Scan(&value); err == nil && value != nil {
+				settings[name] = *value
+			}
+		}
+		poolConnections.observed(conn.Conn(), settings)
+		conn.Release()
+	}
+
+	return PoolSnapshot(pool)
+}
+
+// PoolSnapshot returns the pool stats along with every connection without running a query, it is safe to call
+// while the pool is exhausted
+func PoolSnapshot(pool *pgxpool.Pool) PoolDiagnostics {
+	return PoolDiagnostics{Stats: PoolStatsOf(pool), Connections: poolConnections.snapshot()}
+}
+
+func PoolStatsOf(pool *pgxpool.Pool) PoolStats {
+	stat := pool.Stat()
+	return PoolStats{
+		MaxConns:                stat.MaxConns(),
+		TotalConns:              stat.TotalConns(),
+		AcquiredConns:           stat.AcquiredConns(),
+		IdleConns:               stat.IdleConns(),
+		ConstructingConns:       stat.ConstructingConns(),
+		AcquireCount:            stat.AcquireCount(),
+		AcquireDurationMs:       float64(stat.AcquireDuration().Microseconds()) / 1000,
+		EmptyAcquireCount:       stat.EmptyAcquireCount(),
+		CanceledAcquireCount:    stat.CanceledAcquireCount(),
+		NewConnsCount:           stat.NewConnsCount(),
+		MaxLifetimeDestroyCount: stat.MaxLifetimeDestroyCount(),
+		MaxIdleDestroyCount:     stat.MaxIdleDestroyCount(),
+	}
+}
+
+// Activity is a pg_stat_activity row of one of this service instance's connections
+type Activity struct {
+	Node          string     `json:"node"`
+	PID           int32      `json:"pid"`
+	State         *string    `json:"state"`
+	WaitEventType *string    `json:"wait_event_type"`
+	WaitEvent     *string    `json:"wait_event"`
+	BackendStart  *time.Time `json:"backend_start"`
+	XactStart     *time.Time `json:"xact_start"`
+	QueryStart    *time.Time `json:"query_start"`
+	StateChange   *time.Time `json:"state_change"`
+	Query         *string    `json:"query"`
+}
+
+// NodeError is a node whose activity could not be read
+type NodeError struct {
+	Node  string `json:"node"`
+	Error string `json:"error"`
+}
+
+// PoolActivity reads this instance's rows (by its application_name) from pg_stat_activity on every configured
+// host. It connects outside the pool so it still works when the pool is exhausted, and visits each host because
+// YugabyteDB only reports the backends of the node queried.
+func PoolActivity(ctx context.Context, pool *pgxpool.Pool) ([]Activity, []NodeError) {
+	connConfig := pool.Config().ConnConfig
+	applicationName := connConfig.RuntimeParams["application_name"]
+
+	type node struct {
+		host string
+		port uint16
+	}
+	nodes := []node{{connConfig.Host, connConfig.Port}}
+	for _, fallback := range connConfig.Fallbacks {
+		nodes = append(nodes, node{fallback.Host, fallback.Port})
+	}
+
+	activities := []Activity{}
+	var nodeErrors []NodeError
+	for _, n := range nodes {
+		address := fmt.Sprintf("%s:%d", n.host, n.port)
+		rows, err := nodeActivity(ctx, connConfig, n.host, n.port, applicationName)
+		if err != nil {
+			nodeErrors = append(nodeErrors, NodeError{Node: address, Error: err.Error()})
+			continue
+		}
+		for i := range rows {
+			rows[i].Node = address
+		}
+		activities = append(activities, rows...)
+	}
+
+	return activities, nodeErrors
+}
+
+func nodeActivity(ctx context.Context, connConfig *pgx.ConnConfig, host string, port uint16, applicationName string) ([]Activity, error) {
+	nodeConfig := connConfig.Copy()
+	nodeConfig.Host, nodeConfig.Port, nodeConfig.Fallbacks = host, port, nil
+	// the diagnostic connection is not one of the service's, so it neither shows up in its own results nor traces
+	nodeConfig.RuntimeParams["application_name"] = applicationName + " (admin)"
+	nodeConfig.Tracer = nil
+
+	conn, err := pgx.ConnectConfig(ctx, nodeConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close(context.Background())
+
+	rows, err := conn.Query(ctx, `
+		select pid, state, wait_event_type, wait_event, backend_start, xact_start, query_start, state_change, query
+		  from pg_stat_activity
+		 where application_name = $1
+		 order by pid`, applicationName)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Activity, error) {
+		var a Activity
+		err := row.Scan(&a.PID, &a.State, &a.WaitEventType, &a.WaitEvent, &a.BackendStart, &a.XactStart,
+			&a.QueryStart, &a.StateChange, &a.Query)
+		return a, err
+	})
+}
@@ -0,0 +1,306 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"github.com/ssherwood/ysqlapp/internal/config"
+	"github.com/yugabyte/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// the most distinct tables recorded, the statements on any other table are recorded as otherTable
+	maxQueryMetricTables = 200
+	otherTable           = "_OTHER"
+)
+
+// the statement keywords recorded as the operation, any other is recorded as sqlOperationUnknown
+var queryMetricOperations = map[string]bool{
+	"SELECT": true, "INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true, "UPSERT": true, "WITH": true,
+	"BEGIN": true, "START": true, "COMMIT": true, "ROLLBACK": true, "SAVEPOINT": true, "RELEASE": true,
+	"SET": true, "SHOW": true, "CALL": true, "COPY": true, "VALUES": true, "TRUNCATE": true, "LOCK": true,
+	"CREATE": true, "ALTER": true, "DROP": true, "PREPARE": true, "EXECUTE": true, "DEALLOCATE": true,
+	"ANALYZE": true, "EXPLAIN": true,
+}
+
+var (
+	// a keyword, identifier, number or parameter ($1)
+	sqlWord = regexp.MustCompile(`^[A-Za-z0-9_$]+`)
+	// an unquoted identifier
+	sqlIdentifierStart = regexp.MustCompile(`^[A-Za-z_]`)
+	// the opening of a dollar quoted string, e.g. $$ or $body$
+	sqlDollarQuote = regexp.MustCompile(`^\$[A-Za-z_]*\$`)
+)
+
+// queryMetrics records the duration, rows and errors of every statement by operation and table, whether or not
+// its trace is sampled. Only the statement keyword and the table name parsed from it are attributes, never the SQL
+// text, and the distinct tables are capped so dynamically generated statements cannot grow the series unbounded.
+type queryMetrics struct {
+	duration     metric.Float64Histogram
+	returnedRows metric.Int64Histogram
+	affectedRows metric.Int64Histogram
+	errors       metric.Int64Counter
+
+	mu     sync.Mutex
+	tables map[string]bool
+}
+
+func newQueryMetrics() (*queryMetrics, error) {
+	meter := otel.Meter(tracerName,
+		metric.WithInstrumentationAttributes(
+			semconv.ServiceName(config.ServiceName),
+		),
+	)
+
+	duration, err1 := meter.Float64Histogram("db.client.operation.duration",
+		metric.WithDescription("Duration of database client operations."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10))
+	returnedRows, err2 := meter.Int64Histogram("db.client.response.returned_rows",
+		metric.WithDescription("The number of rows returned by a query."),
+		metric.WithUnit("{row}"),
+		metric.WithExplicitBucketBoundaries(0, 1, 2, 5, 10, 50, 100, 500, 1000, 5000, 10000))
+	affectedRows, err3 := meter.Int64Histogram("db.client.response.affected_rows",
+		metric.WithDescription("The number of rows inserted, updated or deleted by a statement."),
+		metric.WithUnit("{row}"),
+		metric.WithExplicitBucketBoundaries(0, 1, 2, 5, 10, 50, 100, 500, 1000, 5000, 10000))
+	errorCount, err4 := meter.Int64Counter("db.client.operation.errors",
+		metric.WithDescription("The number of database client operations that failed."),
+		metric.WithUnit("{error}"))
+	if err := errors.Join(err1, err2, err3, err4); err != nil {
+		return nil, err
+	}
+
+	return &queryMetrics{
+		duration:     duration,
+		returnedRows: returnedRows,
+		affectedRows: affectedRows,
+		errors:       errorCount,
+		tables:       make(map[string]bool),
+	}, nil
+}
+
+// record the statement that started at start and ended with the command tag or error
+func (m *queryMetrics) record(ctx context.Context, start time.Time, stmt string, tag pgconn.CommandTag, err error) {
+	operation, table := sqlSummary(stmt)
+	attrs := []attribute.KeyValue{semconv.DBSystemPostgreSQL, semconv.DBOperation(operation)}
+	if table != "" {
+		attrs = append(attrs, semconv.DBSQLTable(m.table(table)))
+	}
+
+	if err != nil {
+		errorAttrs := append(attrs, errorType(err))
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			errorAttrs = append(errorAttrs, SQLStateKey.String(pgErr.Code))
+		}
+		m.errors.Add(ctx, 1, metric.WithAttributes(errorAttrs...))
+		m.duration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(errorAttrs...))
+		return
+	}
+
+	m.duration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(attrs...))
+	switch {
+	case tag.Select():
+		m.returnedRows.Record(ctx, tag.RowsAffected(), metric.WithAttributes(attrs...))
+	case tag.Insert(), tag.Update(), tag.Delete():
+		m.affectedRows.Record(ctx, tag.RowsAffected(), metric.WithAttributes(attrs...))
+	}
+}
+
+// table returns the table name, or otherTable once the limit of distinct tables is reached
+func (m *queryMetrics) table(name string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.tables[name] {
+		if len(m.tables) >= maxQueryMetricTables {
+			return otherTable
+		}
+		m.tables[name] = true
+	}
+	return name
+}
+
+// errorType classifies the error by its kind rather than its message
+func errorType(err error) attribute.KeyValue {
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.As(err, &pgErr):
+		return semconv.ErrorTypeKey.String("db_error")
+	case errors.Is(err, context.DeadlineExceeded):
+		return semconv.ErrorTypeKey.String("timeout")
+	case errors.Is(err, context.Canceled):
+		return semconv.ErrorTypeKey.String("canceled")
+	case pgconn.SafeToRetry(err):
+		return semconv.ErrorTypeKey.String("connection")
+	default:
+		return semconv.ErrorTypeOther
+	}
+}
+
+// sqlSummary returns the statement keyword (e.g. SELECT) and the table it reads from or writes to, when it can
+// be told from the statement (e.g. not for a SELECT without FROM or from a subquery). Only the top level of the
+// statement is read, so a FROM in a function call (extract(epoch from ...)) or a subquery does not name the table.
+// A WITH is named by its CTEs' statement unless that reads from one of the CTEs.
+func sqlSummary(stmt string) (operation, table string) {
+	tokens := sqlTopLevelTokens(stmt)
+	if len(tokens) == 0 {
+		return sqlOperationUnknown, ""
+	}
+
+	operation = strings.ToUpper(tokens[0])
+	if !queryMetricOperations[operation] {
+		return sqlOperationUnknown, ""
+	}
+
+	main, ctes := tokens, map[string]bool{}
+	if operation == "WITH" {
+		main = nil
+		for i, token := range tokens[1:] {
+			switch strings.ToUpper(token) {
+			case "SELECT", "INSERT", "UPDATE", "DELETE", "MERGE":
+				main = tokens[1+i:]
+			case "AS", "RECURSIVE", "NOT", "MATERIALIZED", "(", ",":
+			default:
+				ctes[sqlIdentifier(token)] = true
+			}
+			if main != nil {
+				break
+			}
+		}
+		if main == nil {
+			return operation, ""
+		}
+	}
+
+	var after string
+	switch strings.ToUpper(main[0]) {
+	case "SELECT", "DELETE":
+		after = "FROM"
+	case "INSERT", "UPSERT", "MERGE":
+		after = "INTO"
+	case "UPDATE", "TRUNCATE", "LOCK":
+		after = strings.ToUpper(main[0])
+	default:
+		return operation, ""
+	}
+
+	for i, token := range main {
+		if !strings.EqualFold(token, after) {
+			continue
+		}
+		name := main[i+1:]
+		for len(name) > 0 && (strings.EqualFold(name[0], "ONLY") || strings.EqualFold(name[0], "TABLE")) {
+			name = name[1:]
+		}
+		if len(name) == 0 || !sqlName(name[0]) {
+			// e.g. a subquery
+			return operation, ""
+		}
+		table = sqlIdentifier(name[0])
+		if len(name) > 2 && name[1] == "." && sqlName(name[2]) {
+			table += "." + sqlIdentifier(name[2])
+		}
+		if ctes[table] {
+			return operation, ""
+		}
+		return operation, table
+	}
+	return operation, ""
+}
+
+// sqlTopLevelTokens splits the statement into its words, quoted identifiers and punctuation outside any
+// parentheses, a parenthesized part is a single "(". Comments and string literals are skipped.
+func sqlTopLevelTokens(stmt string) []string {
+	var tokens []string
+	depth := 0
+	for i := 0; i < len(stmt); {
+		c := stmt[i]
+		switch {
+		case c == '-' && strings.HasPrefix(stmt[i:], "--"):
+			end := strings.IndexByte(stmt[i:], '\n')
+			if end < 0 {
+				return tokens
+			}
+			i += end + 1
+			continue
+		case c == '/' && strings.HasPrefix(stmt[i:], "/*"):
+			end := strings.Index(stmt[i+2:], "*/")
+			if end < 0 {
+				return tokens
+			}
+			i += 2 + end + 2
+			continue
+		case c == '\'':
+			// a doubled quote is an escaped quote, so it simply continues the literal
+			end := strings.IndexByte(stmt[i+1:], '\'')
+			if end < 0 {
+				return tokens
+			}
+			i += 1 + end + 1
+			continue
+		case c == '$' && sqlDollarQuote.MatchString(stmt[i:]):
+			tag := sqlDollarQuote.FindString(stmt[i:])
+			end := strings.Index(stmt[i+len(tag):], tag)
+			if end < 0 {
+				return tokens
+			}
+			i += len(tag) + end + len(tag)
+			continue
+		case c == '(':
+			if depth == 0 {
+				tokens = append(tokens, "(")
+			}
+			depth++
+			i++
+			continue
+		case c == ')':
+			depth = max(depth-1, 0)
+			i++
+			continue
+		}
+
+		var token string
+		switch {
+		case c == '"':
+			end := strings.IndexByte(stmt[i+1:], '"')
+			if end < 0 {
+				return tokens
+			}
+			token = stmt[i : i+1+end+1]
+		case sqlWord.MatchString(stmt[i:]):
+			token = sqlWord.FindString(stmt[i:])
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		default:
+			token = stmt[i : i+1]
+		}
+		if depth == 0 {
+			tokens = append(tokens, token)
+		}
+		i += len(token)
+	}
+	return tokens
+}
+
+// sqlName reports whether the token is a quoted or unquoted identifier
+func sqlName(token string) bool {
+	return strings.HasPrefix(token, `"`) || sqlIdentifierStart.MatchString(token)
+}
+
+// sqlIdentifier returns the name of an identifier, unquoted identifiers fold to lower case
+func sqlIdentifier(token string) string {
+	if strings.HasPrefix(token, `"`) {
+		return strings.Trim(token, `"`)
+	}
+	return strings.ToLower(token)
+}
@@ -0,0 +1,56 @@
+package shared
+
+import "testing"
+
+func TestSQLSummary(t *testing.T) {
+	tests := []struct {
+		name      string
+		stmt      string
+		operation string
+		table     string
+	}{
+		{"select", "select * from location where id=$1", "SELECT", "location"},
+		{"upper case", "SELECT ID FROM LOCATION", "SELECT", "location"},
+		{"join", "select * from location loc left join address adr on loc.address_id = adr.id", "SELECT", "location"},
+		{"no from", "select 1", "SELECT", ""},
+		{"function with from", "select extract(epoch from now())", "SELECT", ""},
+		{"function with from and table", "select substring(name from 1 for 3) from location", "SELECT", "location"},
+		{"subquery in select list", "select (select active from location where id=$1), exists (select 1 from tag)", "SELECT", ""},
+		{"subquery in from", "select count(*) from (select id from location) ids", "SELECT", ""},
+		{"string literal", "select 'from address' from location", "SELECT", "location"},
+		{"dollar quoted", "select $$ from address $$ from location", "SELECT", "location"},
+		{"line comment", "-- from address\nselect * from location", "SELECT", "location"},
+		{"block comment", "/* from address */ select * /* from tag */ from location", "SELECT", "location"},
+		{"schema qualified", "select * from public.location", "SELECT", "public.location"},
+		{"quoted", `select * from "Location"`, "SELECT", "Location"},
+		{"quoted schema qualified", `select * from "public"."Location"`, "SELECT", "public.Location"},
+		{"only", "select * from only location", "SELECT", "location"},
+		{"insert", "INSERT INTO address (street, city) VALUES ($1, $2)", "INSERT", "address"},
+		{"insert without space", "insert into tag(name, description) values ($1, $2) on conflict (name) do nothing", "INSERT", "tag"},
+		{"upsert", "insert into address (street) values ($1) on conflict (normalized_key) do update set normalized_key=excluded.normalized_key", "INSERT", "address"},
+		{"update", "UPDATE location SET name=$1 WHERE id=$2", "UPDATE", "location"},
+		{"update only", "update only location set name=$1", "UPDATE", "location"},
+		{"delete", "DELETE FROM location_tag WHERE location_id=$1", "DELETE", "location_tag"},
+		{"delete only", "delete from only location_tag", "DELETE", "location_tag"},
+		{"truncate", "truncate table location_tag", "TRUNCATE", "location_tag"},
+		{"lock", "lock table migrations in exclusive mode", "LOCK", "migrations"},
+		{"cte", "with active as (select * from location where active) select * from address", "WITH", "address"},
+		{"cte reading the cte", "with active as (select * from location where active) select * from active", "WITH", ""},
+		{"recursive cte", "with recursive ancestors(id) as (select id from location union select 1) select coalesce((select active from location where id=$1), false), exists (select 1 from ancestors)", "WITH", ""},
+		{"cte update", "with moved as (select id from location) update location set parent_id=null where id in (select id from moved)", "WITH", "location"},
+		{"set", "SET LOCAL yb_read_from_followers = true", "SET", ""},
+		{"begin", "begin", "BEGIN", ""},
+		{"unknown", "vacuum location", sqlOperationUnknown, ""},
+		{"empty", " ", sqlOperationUnknown, ""},
+		{"comment only", "-- nothing", sqlOperationUnknown, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			operation, table := sqlSummary(tt.stmt)
+			if operation != tt.operation || table != tt.table {
+				t.Errorf("sqlSummary(%q) = %q, %q, want %q, %q", tt.stmt, operation, table, tt.operation, tt.table)
+			}
+		})
+	}
+}
@@ -13,9 +13,10 @@ import (
 	"go.opentelemetry.io/otel/codes"
 	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
 	"go.opentelemetry.io/otel/trace"
-	"log"
+	"log/slog"
 	"runtime/debug"
 	"strings"
+	"time"
 )
 
 const (
@@ -40,17 +41,42 @@ const (
 type SpanNameFunc func(stmt string) string
 
 type PgxQueryTracer struct {
+	// nil when OTEL_TRACER_ENABLE is false, the queries are then only measured
 	tracer              trace.Tracer
+	metrics             *queryMetrics
 	attrs               []attribute.KeyValue
 	trimQuerySpanName   bool
 	spanNameFunc        SpanNameFunc
 	prefixQuerySpanName bool
-	logSQLStatement     bool
-	includeParams       bool
+}
+
+type queryStartKey struct{}
+
+// queryStart is when the query started, passed from TraceQueryStart to TraceQueryEnd in the context
+type queryStart struct {
+	at  time.Time
+	sql string
 }
 
 func (t *PgxQueryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
-	log.Println(">>> Query Start:", "sql:", data.SQL, "args:", data.Args)
+	// metrics are recorded for every query, including those whose trace is not sampled or not traced at all
+	if t.metrics != nil {
+		ctx = context.WithValue(ctx, queryStartKey{}, queryStart{at: time.Now(), sql: data.SQL})
+	}
+
+	if t.tracer == nil {
+		return ctx
+	}
+
+	// statement logging can be switched at runtime, so it is read from the current config on every query
+	otelConfig := config.Current().OTel
+	if otelConfig.TracerLogSQLStatement && slog.Default().Enabled(ctx, slog.LevelDebug) {
+		if otelConfig.TracerIncludeParams {
+			slog.DebugContext(ctx, "Query start", "sql", data.SQL, "args", data.Args)
+		} else {
+			slog.DebugContext(ctx, "Query start", "sql", data.SQL)
+		}
+	}
 
 	if !trace.SpanFromContext(ctx).IsRecording() {
 		return ctx
@@ -65,9 +91,9 @@ func (t *PgxQueryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, da
 		opts = append(opts, connectionAttributesFromConfig(conn.Config())...)
 	}
 
-	if t.logSQLStatement {
+	if otelConfig.TracerLogSQLStatement {
 		opts = append(opts, trace.WithAttributes(semconv.DBStatement(data.SQL)))
-		if t.includeParams {
+		if otelConfig.TracerIncludeParams {
 			opts = append(opts, trace.WithAttributes(makeParamsAttribute(data.Args)))
 		}
 	}
@@ -85,7 +111,16 @@ func (t *PgxQueryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, da
 }
 
 func (t *PgxQueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
-	log.Printf(">>> Query End: tag: %v", data.CommandTag)
+	if start, ok := ctx.Value(queryStartKey{}).(queryStart); ok && t.metrics != nil {
+		t.metrics.record(ctx, start.at, start.sql, data.CommandTag, queryError(data.Err))
+	}
+
+	if t.tracer == nil {
+		return
+	}
+	if config.Current().OTel.TracerLogSQLStatement {
+		slog.DebugContext(ctx, "Query end", "tag", data.CommandTag.String())
+	}
 
 	span := trace.SpanFromContext(ctx)
 
@@ -99,13 +134,13 @@ func (t *PgxQueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pg
 }
 
 func (t *PgxQueryTracer) TraceConnectStart(ctx context.Context, data pgx.TraceConnectStartData) context.Context {
-	log.Println(">>> ConnectionStart:", "connString:", maskPostgresPassword(data.ConnConfig.ConnString()))
+	slog.DebugContext(ctx, "Connecting to the database", "connString", maskPostgresPassword(data.ConnConfig.ConnString()))
 	return ctx
 }
 
-func (t *PgxQueryTracer) TraceConnectEnd(_ context.Context, data pgx.TraceConnectEndData) {
+func (t *PgxQueryTracer) TraceConnectEnd(ctx context.Context, data pgx.TraceConnectEndData) {
 	if data.Err != nil {
-		log.Println(">>> ConnectionEnd:", "Err:", data.Err)
+		slog.ErrorContext(ctx, "Failed to connect to the database", config.ErrAttr(data.Err))
 	}
 }
 
@@ -152,6 +187,14 @@ func makeParamsAttribute(args []any) attribute.KeyValue {
 	return QueryParametersKey.StringSlice(ss)
 }
 
+// queryError returns the error the query failed with, nil when it succeeded or found no rows
+func queryError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	return err
+}
+
 func recordSQLError(span trace.Span, err error) {
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		span.RecordError(err)
@@ -177,15 +220,24 @@ func findOwnImportedVersion() string {
 	return "unknown"
 }
 
-func NewQueryTracer(globalAttrs []attribute.KeyValue) pgx.QueryTracer {
-	provider := otel.GetTracerProvider()
+// NewQueryTracer measures every query and, with OTEL_TRACER_ENABLE, traces them
+func NewQueryTracer(ctx context.Context, cfg config.OTelConfig, globalAttrs []attribute.KeyValue) pgx.QueryTracer {
+	var tracer trace.Tracer
+	if cfg.TracerEnabled {
+		tracer = otel.GetTracerProvider().Tracer(tracerName, trace.WithInstrumentationVersion(findOwnImportedVersion()))
+	}
+
+	metrics, err := newQueryMetrics()
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to create the query metrics", config.ErrAttr(err))
+	}
+
 	return &PgxQueryTracer{
-		tracer:              provider.Tracer(tracerName, trace.WithInstrumentationVersion(findOwnImportedVersion())),
+		tracer:              tracer,
+		metrics:             metrics,
 		attrs:               globalAttrs,
 		trimQuerySpanName:   false,
 		spanNameFunc:        nil,
-		prefixQuerySpanName: config.OTELPrefixQuerySpanName,
-		logSQLStatement:     config.OTELTracerLogSQLStatement,
-		includeParams:       config.OTELTracerIncludeParams,
+		prefixQuerySpanName: cfg.PrefixQuerySpanName,
 	}
 }
@@ -0,0 +1,259 @@
+package shared
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/yugabyte/pgx/v5"
+	"github.com/yugabyte/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ReadConsistencyHeader lets a client choose the consistency of its reads, e.g. "strong" or
+	// "bounded-staleness;ms=5000"
+	ReadConsistencyHeader = "X-Read-Consistency"
+	// ConsistencyTokenHeader carries the token returned by a write, presenting it on a later read guarantees
+	// the read sees that write
+	ConsistencyTokenHeader = "X-Consistency-Token"
+
+	// ReadConsistencyStrong reads from the tablet leaders and always sees the latest committed data
+	ReadConsistencyStrong = "strong"
+	// ReadConsistencyBoundedStaleness reads from the closest follower, the data may be up to the staleness old
+	ReadConsistencyBoundedStaleness = "bounded-staleness"
+)
+
+const (
+	// ReadConsistencyKey represents the read consistency mode applied to a read-only transaction.
+	ReadConsistencyKey = attribute.Key("db.yugabytedb.read_consistency")
+	// FollowerReadStalenessKey represents the yb_follower_read_staleness_ms applied to a follower read.
+	FollowerReadStalenessKey = attribute.Key("db.yugabytedb.follower_read_staleness_ms")
+	// ReadYourWritesKey is set when a follower read was upgraded to a leader read because the request presented
+	// a consistency token within the staleness window.
+	ReadYourWritesKey = attribute.Key("db.yugabytedb.read_your_writes")
+)
+
+type readConsistencyKey struct{}
+
+// ReadConsistency is the consistency a read-only transaction runs at, StalenessMs only applies to bounded
+// staleness (follower) reads.
+type ReadConsistency struct {
+	Mode        string
+	StalenessMs int
+}
+
+// ParseReadConsistency parses the X-Read-Consistency format: "strong" or "bounded-staleness;ms=N"
+func ParseReadConsistency(value string) (ReadConsistency, error) {
+	parts := strings.Split(value, ";")
+	mode := strings.ToLower(strings.TrimSpace(parts[0]))
+
+	switch mode {
+	case ReadConsistencyStrong:
+		if len(parts) > 1 {
+			return ReadConsistency{}, fmt.Errorf("read consistency '%s' does not take parameters", value)
+		}
+		return ReadConsistency{Mode: mode}, nil
+	case ReadConsistencyBoundedStaleness:
+		if len(parts) != 2 {
+			return ReadConsistency{}, fmt.Errorf("read consistency '%s' requires ms=N", value)
+		}
+		param := strings.TrimSpace(parts[1])
+		ms, found := strings.CutPrefix(param, "ms=")
+		if !found {
+			return ReadConsistency{}, fmt.Errorf("read consistency '%s' requires ms=N", value)
+		}
+		staleness, err := strconv.Atoi(ms)
+		if err != nil || staleness <= 0 {
+			return ReadConsistency{}, fmt.Errorf("read consistency staleness '%s' must be a positive number of milliseconds", ms)
+		}
+		return ReadConsistency{Mode: mode, StalenessMs: staleness}, nil
+	}
+
+	return ReadConsistency{}, fmt.Errorf("unknown read consistency '%s', expected strong or bounded-staleness;ms=N", value)
+}
+
+func (c ReadConsistency) String() string {
+	if c.Mode == ReadConsistencyBoundedStaleness {
+		return fmt.Sprintf("%s;ms=%d", c.Mode, c.StalenessMs)
+	}
+	return c.Mode
+}
+
+// WithReadConsistency returns a copy of the context carrying the read consistency
+func WithReadConsistency(ctx context.Context, consistency ReadConsistency) context.Context {
+	return context.WithValue(ctx, readConsistencyKey{}, consistency)
+}
+
+// ReadConsistencyFromContext returns the read consistency chosen for the request, strong when none was
+func ReadConsistencyFromContext(ctx context.Context) ReadConsistency {
+	if consistency, ok := ctx.Value(readConsistencyKey{}).(ReadConsistency); ok {
+		return consistency
+	}
+	return ReadConsistency{Mode: ReadConsistencyStrong}
+}
+
+// ReadConsistencyMiddleware puts the consistency requested in the X-Read-Consistency header, or the default
+// when there is no header, into the request context along with the X-Consistency-Token presented, see
+// BeginReadOnly. It also prepares the context to record the commit time of the request's writes for
+// SetConsistencyToken. An invalid header is rejected with 400 Bad Request.
+func ReadConsistencyMiddleware(defaultConsistency ReadConsistency, tokenMargin time.Duration) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			consistency := defaultConsistency
+			if value := r.Header.Get(ReadConsistencyHeader); value != "" {
+				var err error
+				if consistency, err = ParseReadConsistency(value); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+
+			ctx := WithReadConsistency(r.Context(), consistency)
+			ctx = context.WithValue(ctx, commitClockKey{}, &commitClock{})
+			if value := r.Header.Get(ConsistencyTokenHeader); value != "" {
+				writtenAt, err := ParseConsistencyToken(value)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				ctx = context.WithValue(ctx, consistencyTokenKey{}, consistencyToken{writtenAt: writtenAt, margin: tokenMargin})
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+type commitClockKey struct{}
+
+// commitClock is the latest commit time of the request's writes
+type commitClock struct {
+	mu sync.Mutex
+	at time.Time
+}
+
+type consistencyTokenKey struct{}
+
+// consistencyToken is the commit time presented by the request, the margin covers the commit latency after it
+// was read and the clock skew between the database nodes
+type consistencyToken struct {
+	writtenAt time.Time
+	margin    time.Duration
+}
+
+// Commit commits the write transaction. The database clock is read as its last statement and recorded in the
+// request context, so SetConsistencyToken issues a token for the database's time of the write rather than the
+// application host's.
+func Commit(ctx context.Context, tx pgx.Tx) error {
+	var committedAt time.Time
+	if err := tx.QueryRow(ctx, "select clock_timestamp()").Scan(&committedAt); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	if clock, ok := ctx.Value(commitClockKey{}).(*commitClock); ok {
+		clock.mu.Lock()
+		if committedAt.After(clock.at) {
+			clock.at = committedAt
+		}
+		clock.mu.Unlock()
+	}
+	return nil
+}
+
+// ExecCommit runs the single statement write in its own transaction committed by Commit
+func ExecCommit(ctx context.Context, db *MeteredPool, sql string, args ...any) (pgconn.CommandTag, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	commandTag, err := tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return commandTag, err
+	}
+	return commandTag, Commit(ctx, tx)
+}
+
+// SetConsistencyToken stamps a successful write response with a consistency token carrying the latest commit
+// time recorded by Commit, nothing is set when the request committed nothing.
+func SetConsistencyToken(ctx context.Context, w http.ResponseWriter) {
+	clock, ok := ctx.Value(commitClockKey{}).(*commitClock)
+	if !ok {
+		return
+	}
+	clock.mu.Lock()
+	committedAt := clock.at
+	clock.mu.Unlock()
+	if committedAt.IsZero() {
+		return
+	}
+
+	var token [8]byte
+	binary.BigEndian.PutUint64(token[:], uint64(committedAt.UnixMicro()))
+	w.Header().Set(ConsistencyTokenHeader, base64.RawURLEncoding.EncodeToString(token[:]))
+}
+
+// ParseConsistencyToken decodes the commit time from a token issued by SetConsistencyToken
+func ParseConsistencyToken(token string) (time.Time, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(decoded) != 8 {
+		return time.Time{}, fmt.Errorf("invalid %s '%s'", ConsistencyTokenHeader, token)
+	}
+	return time.UnixMicro(int64(binary.BigEndian.Uint64(decoded))), nil
+}
+
+// BeginReadOnly starts a read-only transaction at the context's read consistency. Follower reads are enabled
+// with SET LOCAL so the settings end with the transaction and never leak onto the pooled connection.
+func BeginReadOnly(ctx context.Context, db *MeteredPool) (pgx.Tx, error) {
+	consistency := ReadConsistencyFromContext(ctx)
+	span := trace.SpanFromContext(ctx)
+
+	// a follower read would miss the write of a presented token that the database clock says could still be
+	// within the staleness window, so it reads from the leaders instead
+	if token, ok := ctx.Value(consistencyTokenKey{}).(consistencyToken); ok && consistency.Mode == ReadConsistencyBoundedStaleness {
+		window := time.Duration(consistency.StalenessMs)*time.Millisecond + token.margin
+		var recent bool
+		err := db.QueryRow(ctx, `select clock_timestamp() - $1::timestamptz < $2 * interval '1 microsecond'`,
+			token.writtenAt, window.Microseconds()).Scan(&recent)
+		if err != nil {
+			return nil, err
+		}
+		if recent {
+			consistency = ReadConsistency{Mode: ReadConsistencyStrong}
+			span.SetAttributes(ReadYourWritesKey.Bool(true))
+		}
+	}
+
+	tx, err := db.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+
+	span.SetAttributes(ReadConsistencyKey.String(consistency.Mode))
+
+	if consistency.Mode == ReadConsistencyBoundedStaleness {
+		span.SetAttributes(FollowerReadStalenessKey.Int(consistency.StalenessMs))
+
+		if _, err = tx.Exec(ctx, "SET LOCAL yb_read_from_followers = true"); err == nil {
+			_, err = tx.Exec(ctx, fmt.Sprintf("SET LOCAL yb_follower_read_staleness_ms = %d", consistency.StalenessMs))
+		}
+		if err != nil {
+			_ = tx.Rollback(ctx)
+			return nil, err
+		}
+	}
+
+	return tx, nil
+}
@@ -0,0 +1,73 @@
+package shared
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+const (
+	// RequestIDHeader carries the caller's request ID, one is generated when it is missing and it is always
+	// returned on the response
+	RequestIDHeader = "X-Request-ID"
+	// TenantHeader names the tenant the request is made for
+	TenantHeader = "X-Tenant-ID"
+
+	// longest request ID accepted from a caller, a longer one is replaced rather than logged
+	maxRequestIDLength = 128
+)
+
+const (
+	// RequestIDKey represents the request ID of the HTTP request.
+	RequestIDKey = attribute.Key("http.request.id")
+	// TenantKey represents the tenant the request is made for.
+	TenantKey = attribute.Key("tenant.id")
+)
+
+type requestInfoKey struct{}
+
+// RequestInfo identifies the HTTP request a context belongs to, Route is the matched path template
+type RequestInfo struct {
+	ID     string
+	Tenant string
+	Route  string
+}
+
+// WithRequestInfo returns a copy of the context carrying the request info
+func WithRequestInfo(ctx context.Context, info RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// RequestInfoFromContext returns the request info of the context, false outside a request
+func RequestInfoFromContext(ctx context.Context) (RequestInfo, bool) {
+	info, ok := ctx.Value(requestInfoKey{}).(RequestInfo)
+	return info, ok
+}
+
+// RequestContextMiddleware puts the request ID, tenant and route template into the request context so they can
+// be logged with every record, and adds the request ID and tenant to the request's span.
+func RequestContextMiddleware() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			info := RequestInfo{ID: r.Header.Get(RequestIDHeader), Tenant: r.Header.Get(TenantHeader)}
+			if info.ID == "" || len(info.ID) > maxRequestIDLength {
+				info.ID = uuid.NewString()
+			}
+			if route := mux.CurrentRoute(r); route != nil {
+				info.Route, _ = route.GetPathTemplate()
+			}
+
+			span := trace.SpanFromContext(r.Context())
+			span.SetAttributes(RequestIDKey.String(info.ID))
+			if info.Tenant != "" {
+				span.SetAttributes(TenantKey.String(info.Tenant))
+			}
+
+			w.Header().Set(RequestIDHeader, info.ID)
+			next.ServeHTTP(w, r.WithContext(WithRequestInfo(r.Context(), info)))
+		})
+	}
+}